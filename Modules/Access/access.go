@@ -0,0 +1,142 @@
+package Access
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+)
+
+// Reasons a query is refused
+const (
+	DeniedACL       = "acl"
+	DeniedRateLimit = "rate_limit"
+)
+
+var (
+	accessLogger *Logger.ModuleLogger
+
+	denied = Metrics.NewCounterVec("hopzero_access_denied_total", "Queries refused by the ACL or rate limit", "reason")
+)
+
+func init() {
+	var err error
+	accessLogger, err = Logger.GetLogger("Access")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Access module:", err)
+	}
+}
+
+// Client is who sent a query: a certificate identity, or "ip:<address>" without one.
+// View is the policy view of the listener the query arrived on.
+type Client struct {
+	Identity      string
+	Addr          net.Addr
+	Authenticated bool
+	View          string
+}
+
+type viewKey struct{}
+
+// WithView tags a context with a listener's view, FromHTTP reads it back
+func WithView(ctx context.Context, view string) context.Context {
+	return context.WithValue(ctx, viewKey{}, view)
+}
+
+// InView returns the client attached to a listener's view
+func (c Client) InView(view string) Client {
+	c.View = view
+	return c
+}
+
+// Anonymous identifies a client by its address only
+func Anonymous(addr net.Addr) Client {
+	host := ""
+	if addr != nil {
+		host = addr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	return Client{Identity: "ip:" + host, Addr: addr}
+}
+
+// FromTLS identifies a client by its verified certificate, falling back to its address
+func FromTLS(cs *tls.ConnectionState, addr net.Addr) Client {
+	if cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return Anonymous(addr)
+	}
+	identity := certIdentity(cs.VerifiedChains[0][0])
+	if identity == "" {
+		return Anonymous(addr)
+	}
+	return Client{Identity: identity, Addr: addr, Authenticated: true}
+}
+
+// FromWriter identifies the client of a miekg/dns listener
+func FromWriter(w dns.ResponseWriter) Client {
+	if stater, ok := w.(dns.ConnectionStater); ok {
+		return FromTLS(stater.ConnectionState(), w.RemoteAddr())
+	}
+	return Anonymous(w.RemoteAddr())
+}
+
+// FromHTTP identifies the client of a DoH request
+func FromHTTP(r *http.Request) Client {
+	var addr net.Addr
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		addr = net.TCPAddrFromAddrPort(ap)
+	}
+	view, _ := r.Context().Value(viewKey{}).(string)
+	return FromTLS(r.TLS, addr).InView(view)
+}
+
+func (c Client) String() string {
+	s := c.Identity
+	if c.Authenticated && c.Addr != nil {
+		s = fmt.Sprintf("%s (%s)", c.Identity, c.Addr)
+	}
+	if c.View != "" {
+		s += " [" + c.View + "]"
+	}
+	return s
+}
+
+// Check applies the ACL and the rate limit, returning the refusal reason or "" if allowed
+func Check(c Client) string {
+	if !aclAllows(c.View, c.Identity) {
+		denied.With(DeniedACL).Inc()
+		accessLogger.Warn(fmt.Sprintf("🚫 ACL denied %s", c))
+		return DeniedACL
+	}
+	if !limiter.allow(c.Identity) {
+		denied.With(DeniedRateLimit).Inc()
+		return DeniedRateLimit
+	}
+	return ""
+}
+
+// aclAllows walks the view's ACL, or the global one when the view has none,
+// in order; the first matching glob decides
+func aclAllows(view, identity string) bool {
+	conf := Loader.Current()
+	rules := conf.Access.ACL
+	if v := conf.FindView(view); v != nil && len(v.ACL) > 0 {
+		rules = v.ACL
+	}
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Identity, identity); ok {
+			return strings.EqualFold(rule.Action, "allow")
+		}
+	}
+	return true
+}
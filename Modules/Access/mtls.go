@@ -0,0 +1,231 @@
+package Access
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	mtlsRejected = Metrics.NewCounter("hopzero_mtls_revoked_total", "Client certificates rejected as revoked")
+
+	clientCAs *x509.CertPool
+	caCerts   []*x509.Certificate
+
+	// revoked holds issuer subject + serial of every revoked client certificate
+	revoked     atomic.Pointer[map[string]bool]
+	revokedOnce sync.Once
+)
+
+// ConfigureClientAuth adds client certificate verification to a listener's TLS config when mtls is enabled
+func ConfigureClientAuth(tlsConfig *tls.Config) error {
+	conf := Loader.Current().MTLS
+	if !conf.Enabled {
+		return nil
+	}
+
+	var err error
+	revokedOnce.Do(func() {
+		err = loadClientCAs(conf.CAFile)
+		if err == nil {
+			err = reloadRevocations()
+		}
+		if interval := Loader.Current().TLS.ReloadInterval; err == nil && interval > 0 {
+			go watchRevocations(time.Duration(interval) * time.Second)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if clientCAs == nil {
+		return fmt.Errorf("client CA bundle %s could not be loaded", conf.CAFile)
+	}
+
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if conf.Mode == "required" {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	tlsConfig.VerifyConnection = checkRevocation
+	return nil
+}
+
+func loadClientCAs(caFile string) error {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid certificate in %s: %w", caFile, err)
+		}
+		pool.AddCert(cert)
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	clientCAs, caCerts = pool, certs
+	accessLogger.Info(fmt.Sprintf("🔏 Client certificates verified against %d CA(s) from %s", len(certs), caFile))
+	return nil
+}
+
+// checkRevocation runs after chain verification and rejects leaf certificates listed as revoked
+func checkRevocation(cs tls.ConnectionState) error {
+	list := revoked.Load()
+	if list == nil {
+		return nil
+	}
+	for _, chain := range cs.VerifiedChains {
+		if len(chain) < 2 {
+			continue
+		}
+		leaf, issuer := chain[0], chain[1]
+		if (*list)[revocationKey(issuer.RawSubject, leaf.SerialNumber.String())] {
+			mtlsRejected.Inc()
+			accessLogger.Warn(fmt.Sprintf("🚫 Rejected revoked client certificate %s (serial %s)", leaf.Subject, leaf.SerialNumber))
+			return fmt.Errorf("client certificate has been revoked")
+		}
+	}
+	return nil
+}
+
+func revocationKey(issuerSubject []byte, serial string) string {
+	return string(issuerSubject) + "/" + serial
+}
+
+// reloadRevocations reads every CRL and OCSP file; entries not signed by a client CA are ignored
+func reloadRevocations() error {
+	conf := Loader.Current().MTLS
+	list := make(map[string]bool)
+
+	for _, file := range conf.CRLFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read CRL %s: %w", file, err)
+		}
+		if block, _ := pem.Decode(data); block != nil {
+			data = block.Bytes
+		}
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return fmt.Errorf("invalid CRL %s: %w", file, err)
+		}
+		issuer := findIssuer(func(ca *x509.Certificate) bool { return crl.CheckSignatureFrom(ca) == nil })
+		if issuer == nil {
+			return fmt.Errorf("CRL %s is not signed by a configured client CA", file)
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			list[revocationKey(issuer.RawSubject, entry.SerialNumber.String())] = true
+		}
+	}
+
+	for _, file := range conf.OCSPFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read OCSP response %s: %w", file, err)
+		}
+		var resp *ocsp.Response
+		issuer := findIssuer(func(ca *x509.Certificate) bool {
+			resp, err = ocsp.ParseResponse(data, ca)
+			return err == nil
+		})
+		if issuer == nil {
+			return fmt.Errorf("OCSP response %s is not valid for a configured client CA", file)
+		}
+		if resp.Status == ocsp.Revoked {
+			list[revocationKey(issuer.RawSubject, resp.SerialNumber.String())] = true
+		}
+	}
+
+	revoked.Store(&list)
+	if len(conf.CRLFiles)+len(conf.OCSPFiles) > 0 {
+		accessLogger.Info(fmt.Sprintf("📜 Loaded %d revoked client certificate(s)", len(list)))
+	}
+	return nil
+}
+
+func findIssuer(signedBy func(*x509.Certificate) bool) *x509.Certificate {
+	for _, ca := range caCerts {
+		if signedBy(ca) {
+			return ca
+		}
+	}
+	return nil
+}
+
+// watchRevocations rereads the CRL and OCSP files when any of them changes
+func watchRevocations(interval time.Duration) {
+	conf := Loader.Current().MTLS
+	files := append(append([]string{}, conf.CRLFiles...), conf.OCSPFiles...)
+	seen := modTimes(files)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		current := modTimes(files)
+		if current == seen {
+			continue
+		}
+		if err := reloadRevocations(); err != nil {
+			accessLogger.Warn("⚠️ Revocation files changed but could not be loaded yet: " + err.Error())
+			continue
+		}
+		seen = current
+	}
+}
+
+func modTimes(files []string) string {
+	var stamp string
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamp += info.ModTime().String()
+		}
+		stamp += "|"
+	}
+	return stamp
+}
+
+// certIdentity names a client from the configured certificate field, then applies the rename map
+func certIdentity(cert *x509.Certificate) string {
+	conf := Loader.Current().MTLS
+	var raw string
+	switch conf.IdentitySource {
+	case "san_dns":
+		if len(cert.DNSNames) > 0 {
+			raw = cert.DNSNames[0]
+		}
+	case "san_email":
+		if len(cert.EmailAddresses) > 0 {
+			raw = cert.EmailAddresses[0]
+		}
+	case "san_uri":
+		if len(cert.URIs) > 0 {
+			raw = cert.URIs[0].String()
+		}
+	default:
+		raw = cert.Subject.CommonName
+	}
+
+	if mapped, ok := conf.Identities[raw]; ok {
+		return mapped
+	}
+	return raw
+}
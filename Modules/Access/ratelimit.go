@@ -0,0 +1,66 @@
+package Access
+
+import (
+	"sync"
+	"time"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+)
+
+// Buckets idle this long are dropped, a full bucket carries no state worth keeping
+const bucketIdle = 5 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client identity
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+var limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+
+func (l *rateLimiter) allow(identity string) bool {
+	conf := Loader.Current().Access.RateLimit
+	if conf.QueriesPerSecond <= 0 {
+		return true
+	}
+	burst := float64(conf.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.swept) > bucketIdle {
+		for id, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdle {
+				delete(l.buckets, id)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[identity]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[identity] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * conf.QueriesPerSecond
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,225 @@
+package CLI
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Do53"
+)
+
+// benchSettings are the key=value arguments of hopzero bench
+type benchSettings struct {
+	addr     string
+	duration time.Duration
+	clients  int
+	name     string
+	sockets  []int
+	workers  int
+}
+
+// benchResult counts one load run
+type benchResult struct {
+	sent      int
+	answered  int
+	latencies []time.Duration
+	err       error // last read error other than a timeout
+}
+
+func runBench(args []string) error {
+	if len(args) == 0 || (args[0] != "udp" && args[0] != "reuseport") {
+		return fmt.Errorf("%s", benchUsage)
+	}
+
+	s := benchSettings{addr: "127.0.0.1:53", duration: 5 * time.Second, clients: 64, name: "bench.hopzero.test."}
+	for n := 1; n < runtime.GOMAXPROCS(0); n *= 2 {
+		s.sockets = append(s.sockets, n)
+	}
+	s.sockets = append(s.sockets, runtime.GOMAXPROCS(0))
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q\n%s", arg, benchUsage)
+		}
+
+		var err error
+		switch key {
+		case "addr":
+			s.addr = value
+		case "duration":
+			s.duration, err = time.ParseDuration(value)
+		case "clients":
+			s.clients, err = strconv.Atoi(value)
+		case "name":
+			s.name = dns.Fqdn(value)
+		case "sockets":
+			s.sockets = nil
+			for _, field := range strings.Split(value, ",") {
+				var n int
+				if n, err = strconv.Atoi(field); err != nil || n < 1 {
+					return fmt.Errorf("invalid sockets: %q is not a positive count", field)
+				}
+				s.sockets = append(s.sockets, n)
+			}
+		case "workers":
+			s.workers, err = strconv.Atoi(value)
+		default:
+			return fmt.Errorf("unknown key %q\n%s", key, benchUsage)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	if s.clients < 1 || s.duration <= 0 {
+		return fmt.Errorf("clients and duration must be positive")
+	}
+
+	if args[0] == "udp" {
+		fmt.Printf("📊 %s to %s, %d clients for %s\n", s.name, s.addr, s.clients, s.duration)
+		result, err := loadUDP(s.addr, s.name, s.clients, s.duration)
+		if err != nil {
+			return err
+		}
+		printBenchHeader("")
+		printBenchRow("", result, s.duration)
+		return nil
+	}
+	return benchReusePort(s)
+}
+
+// benchReusePort serves a fixed answer from an in-process listener on 127.0.0.1 with each
+// socket count in turn and loads it from the same process. The clients share the CPUs, so
+// the numbers show how the server scales, not what a separate load generator would reach.
+func benchReusePort(s benchSettings) error {
+	answer := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		_ = w.WriteMsg(m)
+	})
+
+	fmt.Printf("📊 UDP SO_REUSEPORT scaling on loopback, %d clients for %s per run, GOMAXPROCS %d\n", s.clients, s.duration, runtime.GOMAXPROCS(0))
+	printBenchHeader("sockets")
+	for _, n := range s.sockets {
+		srv := Do53.NewDo53Server("udp", "127.0.0.1:0", n, s.workers, answer)
+		if err := srv.Start(); err != nil {
+			return err
+		}
+		result, err := loadUDP(srv.Servers[0].PacketConn.LocalAddr().String(), s.name, s.clients, s.duration)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = srv.Stop(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		printBenchRow(strconv.Itoa(srv.Sockets), result, s.duration)
+	}
+	return nil
+}
+
+// loadUDP runs clients, each on its own socket so the kernel hashes them across the server's
+// sockets, sending one query at a time until duration is over. A query unanswered within a
+// second counts as lost.
+func loadUDP(addr, name string, clients int, duration time.Duration) (benchResult, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return benchResult{}, err
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(name, dns.TypeA)
+	wire, err := query.Pack()
+	if err != nil {
+		return benchResult{}, err
+	}
+
+	conns := make([]*net.UDPConn, clients)
+	for i := range conns {
+		if conns[i], err = net.DialUDP("udp", nil, raddr); err != nil {
+			for _, c := range conns[:i] {
+				c.Close()
+			}
+			return benchResult{}, err
+		}
+	}
+
+	results := make([]benchResult, clients)
+	deadline := time.Now().Add(duration)
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			msg := slices.Clone(wire)
+			buf := make([]byte, dns.MinMsgSize)
+			r := &results[i]
+			for id := uint16(i); time.Now().Before(deadline); id++ {
+				binary.BigEndian.PutUint16(msg, id)
+				start := time.Now()
+				_ = conn.SetReadDeadline(start.Add(time.Second))
+				r.sent++
+				if _, err := conn.Write(msg); err != nil {
+					continue
+				}
+				// Skip late answers to earlier, already lost queries
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+							r.err = err
+						}
+						break
+					}
+					if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+						r.answered++
+						r.latencies = append(r.latencies, time.Since(start))
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var total benchResult
+	for _, r := range results {
+		total.sent += r.sent
+		total.answered += r.answered
+		total.latencies = append(total.latencies, r.latencies...)
+		if r.err != nil {
+			total.err = r.err
+		}
+	}
+	if total.answered == 0 && total.err != nil {
+		return total, fmt.Errorf("no answers from %s: %w", addr, total.err)
+	}
+	slices.Sort(total.latencies)
+	return total, nil
+}
+
+func printBenchHeader(first string) {
+	fmt.Printf("%-8s %12s %10s %10s %8s\n", first, "queries/s", "p50", "p99", "lost")
+}
+
+func printBenchRow(first string, r benchResult, duration time.Duration) {
+	percentile := func(p float64) time.Duration {
+		if len(r.latencies) == 0 {
+			return 0
+		}
+		return r.latencies[int(float64(len(r.latencies)-1)*p)]
+	}
+	fmt.Printf("%-8s %12.0f %10s %10s %8d\n", first, float64(r.answered)/duration.Seconds(),
+		percentile(0.50).Round(time.Microsecond), percentile(0.99).Round(time.Microsecond), r.sent-r.answered)
+}
@@ -0,0 +1,239 @@
+package CLI
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Cache"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/DNSCrypt"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Policy"
+)
+
+const cacheUsage = `usage:
+  hopzero cache flush name <name>          drop every cached type for a name
+  hopzero cache flush type <name> <type>   drop one name and type
+  hopzero cache flush zone <suffix>        drop a name and everything below it
+  hopzero cache flush all                  drop the whole cache namespace
+  hopzero cache dump [file]                write the Redis cache to a snapshot
+  hopzero cache load [file]                warm Redis from a snapshot
+  hopzero cache inspect [file]             print a snapshot as JSON
+
+[file] defaults to cache.snapshot.path from Config.yaml`
+
+const policyUsage = `usage:
+  hopzero policy simulate [key=value ...]  show which route resolver mode auto would take
+
+keys (all optional):
+  forwarder_latency=20ms   smoothed forwarder latency
+  recursion_latency=250ms  smoothed recursion latency for the zone
+  failure_rate=0.2         share of failed recursions for the zone, 0 to 1
+  samples=50               recursions observed for the zone
+  since_recursion=10s      time since the zone last used recursion
+  forwarder_down=true      no healthy forwarder upstream
+  dnssec=true              client set DO without CD
+  rule=forward             a configured rule forces this route
+
+thresholds come from resolver.policy in Config.yaml`
+
+const dnscryptUsage = `usage:
+  hopzero dnscrypt keygen                  create the provider key at dnscrypt.provider_key_file
+  hopzero dnscrypt stamp <ip:port>         print the provider public key and sdns:// stamp
+
+the key file is never overwritten, remove it first to start over`
+
+const benchUsage = `usage:
+  hopzero bench udp [key=value ...]        load a running UDP listener
+  hopzero bench reuseport [key=value ...]  compare SO_REUSEPORT socket counts on loopback
+
+keys (all optional):
+  addr=127.0.0.1:53        listener to load, bench udp only
+  duration=5s              length of each run
+  clients=64               concurrent clients, each on its own socket
+  name=bench.hopzero.test. name queried, type A
+  sockets=1,2,4,8          socket counts to compare, bench reuseport only, up to GOMAXPROCS by default
+  workers=0                queries in flight per socket, bench reuseport only, 0 for no limit`
+
+// Run executes a command-line subcommand such as "cache flush ..."
+func Run(args []string) error {
+	switch args[0] {
+	case "cache":
+		return runCache(args[1:])
+	case "policy":
+		return runPolicy(args[1:])
+	case "dnscrypt":
+		return runDNSCrypt(args[1:])
+	case "bench":
+		return runBench(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s\n\n%s\n\n%s\n\n%s", args[0], cacheUsage, policyUsage, dnscryptUsage, benchUsage)
+	}
+}
+
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", cacheUsage)
+	}
+
+	switch args[0] {
+	case "flush":
+		return runFlush(args[1:])
+	case "dump", "load", "inspect":
+		return runSnapshot(args[0], args[1:])
+	default:
+		return fmt.Errorf("unknown cache command %q\n%s", args[0], cacheUsage)
+	}
+}
+
+func runFlush(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", cacheUsage)
+	}
+
+	scope := Cache.Scope{Kind: args[0]}
+	switch {
+	case scope.Kind == Cache.ScopeAll && len(args) == 1:
+	case (scope.Kind == Cache.ScopeName || scope.Kind == Cache.ScopeZone) && len(args) == 2:
+		scope.Name = args[1]
+	case scope.Kind == Cache.ScopeType && len(args) == 3:
+		scope.Name = args[1]
+		qtype, ok := dns.StringToType[strings.ToUpper(args[2])]
+		if !ok {
+			return fmt.Errorf("unknown record type %q", args[2])
+		}
+		scope.Qtype = qtype
+	default:
+		return fmt.Errorf("%s", cacheUsage)
+	}
+
+	deleted, err := Cache.Flush(scope, actor())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🧹 Flushed %s: %d Redis keys removed, invalidation broadcast to all instances\n", scope, deleted)
+	return nil
+}
+
+func runSnapshot(command string, args []string) error {
+	path := Loader.Current().Cache.Snapshot.Path
+	switch {
+	case len(args) == 1:
+		path = args[0]
+	case len(args) > 1 || path == "":
+		return fmt.Errorf("%s", cacheUsage)
+	}
+
+	switch command {
+	case "dump":
+		n, err := Cache.WriteSnapshot(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("💾 Wrote %d entries to %s\n", n, path)
+	case "load":
+		n, err := Cache.LoadSnapshot(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("♻️ Loaded %d live entries from %s\n", n, path)
+	case "inspect":
+		snap, err := Cache.ReadSnapshot(path)
+		if err != nil {
+			return err
+		}
+		out, err := snap.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	}
+	return nil
+}
+
+func runPolicy(args []string) error {
+	if len(args) == 0 || args[0] != "simulate" {
+		return fmt.Errorf("%s", policyUsage)
+	}
+
+	s := Policy.Signals{ForwarderHealthy: true, Zone: "simulated."}
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q\n%s", arg, policyUsage)
+		}
+
+		var err error
+		switch key {
+		case "forwarder_latency":
+			s.ForwarderLatency, err = time.ParseDuration(value)
+		case "recursion_latency":
+			s.RecursionLatency, err = time.ParseDuration(value)
+		case "failure_rate":
+			s.RecursionFailureRate, err = strconv.ParseFloat(value, 64)
+		case "samples":
+			s.RecursionSamples, err = strconv.Atoi(value)
+		case "since_recursion":
+			s.SinceRecursion, err = time.ParseDuration(value)
+		case "forwarder_down":
+			var down bool
+			down, err = strconv.ParseBool(value)
+			s.ForwarderHealthy = !down
+		case "dnssec":
+			s.DNSSECRequested, err = strconv.ParseBool(value)
+		case "rule":
+			if value != Policy.RouteRecurse && value != Policy.RouteForward {
+				return fmt.Errorf("rule must be %s or %s", Policy.RouteRecurse, Policy.RouteForward)
+			}
+			s.Rule, s.RuleRoute = "simulated.", value
+		default:
+			return fmt.Errorf("unknown key %q\n%s", key, policyUsage)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	d := Policy.Decide(s, Policy.Configured())
+	fmt.Printf("🧠 %s: %s\n", d.Route, d.Reason)
+	return nil
+}
+
+func runDNSCrypt(args []string) error {
+	conf := Loader.Current().DNSCrypt
+	var key ed25519.PrivateKey
+	var err error
+	switch {
+	case len(args) == 1 && args[0] == "keygen":
+		key, err = DNSCrypt.GenerateProviderKey(conf.ProviderKeyFile)
+		if err == nil {
+			fmt.Println("🔑 Provider key written to " + conf.ProviderKeyFile)
+		}
+	case len(args) == 2 && args[0] == "stamp":
+		key, err = DNSCrypt.LoadProviderKey(conf.ProviderKeyFile)
+	default:
+		return fmt.Errorf("%s", dnscryptUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	public := key.Public().(ed25519.PublicKey)
+	fmt.Printf("provider name: %s\npublic key:    %s\n", conf.ProviderName, DNSCrypt.Fingerprint(public))
+	if args[0] == "stamp" {
+		fmt.Println("stamp:         " + DNSCrypt.Stamp(args[1], conf.ProviderName, public))
+	}
+	return nil
+}
+
+// actor identifies who ran the command in the audit log
+func actor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
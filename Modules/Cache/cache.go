@@ -0,0 +1,96 @@
+package Cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Redis"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	cacheLogger *Logger.ModuleLogger
+
+	memoryHits = Metrics.NewCounter("hopzero_cache_memory_hits_total", "Lookups answered from the in-memory tier")
+	redisHits  = Metrics.NewCounter("hopzero_cache_redis_hits_total", "Lookups answered from Redis")
+	misses     = Metrics.NewCounter("hopzero_cache_misses_total", "Lookups found in neither tier")
+)
+
+func init() {
+	var err error
+	cacheLogger, err = Logger.GetLogger("Cache")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Cache module:", err)
+	}
+}
+
+// InitCache sizes the in-memory tier and subscribes to invalidations from other instances
+func InitCache() {
+	limit := Loader.Current().Cache.MemoryEntries
+	memory.configure(limit)
+	if limit > 0 {
+		cacheLogger.Info(fmt.Sprintf("In-memory cache tier enabled for up to %d entries", limit))
+	}
+	ListenForInvalidations()
+}
+
+// Lookup returns a cached entry for the key if it exists and has not expired
+func Lookup(key string) (*Entry, bool) {
+	if entry, ok := memory.get(key); ok {
+		memoryHits.Inc()
+		return entry, true
+	}
+
+	val, err := Redis.Get(key)
+	if err != nil {
+		if !errors.Is(err, redis.Nil) && !errors.Is(err, Redis.ErrUnavailable) {
+			cacheLogger.Warn(fmt.Sprintf("Cache read failed for %s: %v", key, err))
+		}
+		misses.Inc()
+		return nil, false
+	}
+
+	entry, err := UnmarshalEntry(val)
+	if err != nil {
+		cacheLogger.Warn(fmt.Sprintf("Discarding undecodable cache entry %s: %v", key, err))
+		_ = Redis.Del(key)
+		misses.Inc()
+		return nil, false
+	}
+	if entry.Remaining(time.Now()) == 0 {
+		misses.Inc()
+		return nil, false
+	}
+	redisHits.Inc()
+	memory.put(key, entry)
+	return entry, true
+}
+
+// Store writes an entry to both tiers, expiring it when its remaining TTL runs out
+func Store(key string, entry *Entry) error {
+	remaining := entry.Remaining(time.Now())
+	if remaining == 0 {
+		return nil
+	}
+	memory.put(key, entry)
+	val, err := entry.Marshal()
+	if err != nil {
+		return err
+	}
+	return Redis.Set(key, val, time.Duration(remaining)*time.Second)
+}
+
+// LookupRR is Lookup for an answer set keyed by question and DO/CD bits
+func LookupRR(q dns.Question, do, cd bool) (*Entry, bool) {
+	return Lookup(RRKey(q, do, cd))
+}
+
+// StoreRR is Store for an answer set keyed by question and DO/CD bits
+func StoreRR(q dns.Question, do, cd bool, entry *Entry) error {
+	return Store(RRKey(q, do, cd), entry)
+}
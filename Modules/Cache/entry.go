@@ -0,0 +1,121 @@
+package Cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Entry value layout (big endian):
+//
+//	offset 0   uint8   format version (entryFormat)
+//	offset 1   uint8   flags, bit 0 = DNSSEC secure
+//	offset 2   uint16  rcode, extended rcodes included
+//	offset 4   int64   stored at, unix seconds
+//	offset 12  uint32  TTL at store time, in seconds
+//	offset 16  ...     packed DNS message carrying the records in its answer section
+const (
+	entryFormat     = 2
+	entryHeaderSize = 16
+	flagSecure      = 1 << 0
+)
+
+// Entry is a cached answer set together with the metadata needed to serve it
+type Entry struct {
+	Answer   []dns.RR
+	Rcode    int
+	Secure   bool
+	StoredAt time.Time
+	TTL      uint32
+}
+
+// NewEntry wraps an answer set, using the smallest record TTL as the entry TTL
+func NewEntry(answer []dns.RR, rcode int, secure bool) *Entry {
+	var ttl uint32
+	for i, rr := range answer {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return &Entry{
+		Answer:   answer,
+		Rcode:    rcode,
+		Secure:   secure,
+		StoredAt: time.Now(),
+		TTL:      ttl,
+	}
+}
+
+// Remaining returns how many seconds the entry is still valid for at the given time
+func (e *Entry) Remaining(now time.Time) uint32 {
+	elapsed := now.Sub(e.StoredAt)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	age := uint32(elapsed / time.Second)
+	if age >= e.TTL {
+		return 0
+	}
+	return e.TTL - age
+}
+
+// Records returns copies of the answers with TTLs decremented by the entry's age
+func (e *Entry) Records(now time.Time) []dns.RR {
+	age := e.TTL - e.Remaining(now)
+	records := make([]dns.RR, 0, len(e.Answer))
+	for _, rr := range e.Answer {
+		cp := dns.Copy(rr)
+		if cp.Header().Ttl > age {
+			cp.Header().Ttl -= age
+		} else {
+			cp.Header().Ttl = 0
+		}
+		records = append(records, cp)
+	}
+	return records
+}
+
+// Marshal encodes the entry into its binary wire-format value
+func (e *Entry) Marshal() ([]byte, error) {
+	msg := new(dns.Msg)
+	msg.Answer = e.Answer
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack answers: %w", err)
+	}
+
+	buf := make([]byte, entryHeaderSize, entryHeaderSize+len(packed))
+	buf[0] = entryFormat
+	if e.Secure {
+		buf[1] |= flagSecure
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(e.Rcode))
+	binary.BigEndian.PutUint64(buf[4:12], uint64(e.StoredAt.Unix()))
+	binary.BigEndian.PutUint32(buf[12:16], e.TTL)
+	return append(buf, packed...), nil
+}
+
+// UnmarshalEntry decodes a value produced by Entry.Marshal
+func UnmarshalEntry(b []byte) (*Entry, error) {
+	if len(b) < entryHeaderSize {
+		return nil, fmt.Errorf("cache entry too short: %d bytes", len(b))
+	}
+	if b[0] != entryFormat {
+		return nil, fmt.Errorf("unsupported cache entry format %d", b[0])
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b[entryHeaderSize:]); err != nil {
+		return nil, fmt.Errorf("failed to unpack answers: %w", err)
+	}
+
+	return &Entry{
+		Answer:   msg.Answer,
+		Rcode:    int(binary.BigEndian.Uint16(b[2:4])),
+		Secure:   b[1]&flagSecure != 0,
+		StoredAt: time.Unix(int64(binary.BigEndian.Uint64(b[4:12])), 0),
+		TTL:      binary.BigEndian.Uint32(b[12:16]),
+	}, nil
+}
@@ -0,0 +1,207 @@
+package Cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Redis"
+)
+
+// Scope kinds accepted by Flush
+const (
+	ScopeName = "name" // every type cached for one owner name
+	ScopeType = "type" // one owner name and type
+	ScopeZone = "zone" // a name and everything below it
+	ScopeAll  = "all"  // the whole namespace
+)
+
+// Scope selects the cache entries a flush removes
+type Scope struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name,omitempty"`
+	Qtype uint16 `json:"qtype,omitempty"`
+}
+
+// invalidation is the message broadcast to the other instances
+type invalidation struct {
+	Origin string `json:"origin"`
+	Scope  Scope  `json:"scope"`
+}
+
+var (
+	auditLogger *Logger.ModuleLogger
+	instanceID  = fmt.Sprintf("%s:%d", hostname(), os.Getpid())
+)
+
+func init() {
+	var err error
+	auditLogger, err = Logger.GetLogger("Audit")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Audit:", err)
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+func invalidationChannel() string {
+	return namespace() + ":invalidate"
+}
+
+// Validate normalises the scope and rejects incomplete ones
+func (s *Scope) Validate() error {
+	switch s.Kind {
+	case ScopeAll:
+		return nil
+	case ScopeName, ScopeZone:
+	case ScopeType:
+		if s.Qtype == 0 {
+			return fmt.Errorf("flush by type needs a query type")
+		}
+	default:
+		return fmt.Errorf("unknown flush scope %q", s.Kind)
+	}
+	if s.Name == "" {
+		return fmt.Errorf("flush by %s needs a name", s.Kind)
+	}
+	if _, ok := dns.IsDomainName(s.Name); !ok {
+		return fmt.Errorf("invalid domain name %q", s.Name)
+	}
+	s.Name = canonicalName(s.Name)
+	return nil
+}
+
+func (s Scope) String() string {
+	switch s.Kind {
+	case ScopeAll:
+		return "all"
+	case ScopeType:
+		return fmt.Sprintf("%s %s", s.Name, dns.TypeToString[s.Qtype])
+	default:
+		return fmt.Sprintf("%s %s", s.Kind, s.Name)
+	}
+}
+
+// matches reports whether a key of this namespace falls inside the scope
+func (s Scope) matches(key string) bool {
+	kind, qname, qtype, ok := parseKey(key)
+	if !ok {
+		return false
+	}
+	switch s.Kind {
+	case ScopeAll:
+		return true
+	case ScopeName:
+		return qname == s.Name
+	case ScopeType:
+		return qname == s.Name && qtype == s.Qtype && (kind == "rr" || s.Qtype == dns.TypeDNSKEY)
+	case ScopeZone:
+		return s.Name == "." || qname == s.Name || strings.HasSuffix(qname, "."+s.Name)
+	}
+	return false
+}
+
+// patterns returns the Redis MATCH patterns covering the scope
+func (s Scope) patterns() []string {
+	ns := namespace()
+	name := globEscape(s.Name)
+	switch s.Kind {
+	case ScopeName:
+		return []string{
+			fmt.Sprintf("%s:rr:%s:*", ns, name),
+			fmt.Sprintf("%s:dnskey:%s", ns, name),
+		}
+	case ScopeType:
+		if s.Qtype == dns.TypeDNSKEY {
+			return []string{
+				fmt.Sprintf("%s:rr:%s:*:%d:*", ns, name, s.Qtype),
+				fmt.Sprintf("%s:dnskey:%s", ns, name),
+			}
+		}
+		return []string{fmt.Sprintf("%s:rr:%s:*:%d:*", ns, name, s.Qtype)}
+	case ScopeZone:
+		if s.Name != "." {
+			return []string{
+				fmt.Sprintf("%s:rr:%s:*", ns, name),
+				fmt.Sprintf("%s:rr:*.%s:*", ns, name),
+				fmt.Sprintf("%s:dnskey:%s", ns, name),
+				fmt.Sprintf("%s:dnskey:*.%s", ns, name),
+			}
+		}
+	}
+	return []string{globEscape(ns) + ":*"}
+}
+
+// Flush deletes the scope from Redis and this instance, then tells every other
+// instance to drop it from memory. Each flush is written to the audit log.
+func Flush(scope Scope, actor string) (int64, error) {
+	if err := scope.Validate(); err != nil {
+		return 0, err
+	}
+
+	local := memory.purge(scope.matches)
+
+	var deleted int64
+	for _, pattern := range scope.patterns() {
+		n, err := Redis.DeleteMatching(pattern)
+		deleted += n
+		if err != nil {
+			audit(fmt.Sprintf("❌ Flush %s by %s failed after %d Redis keys: %v", scope, actor, deleted, err))
+			return deleted, fmt.Errorf("failed to delete keys from Redis: %w", err)
+		}
+	}
+
+	payload, _ := json.Marshal(invalidation{Origin: instanceID, Scope: scope})
+	if err := Redis.Publish(invalidationChannel(), payload); err != nil {
+		audit(fmt.Sprintf("⚠️ Flush %s by %s removed %d Redis keys and %d local entries, but broadcast failed: %v", scope, actor, deleted, local, err))
+		return deleted, fmt.Errorf("failed to broadcast invalidation: %w", err)
+	}
+
+	audit(fmt.Sprintf("🧹 Flush %s by %s removed %d Redis keys and %d local entries, broadcast from %s", scope, actor, deleted, local, instanceID))
+	return deleted, nil
+}
+
+// ListenForInvalidations applies flushes broadcast by other instances to the memory tier
+func ListenForInvalidations() {
+	sub := Redis.Subscribe(invalidationChannel())
+	if sub == nil {
+		cacheLogger.Warn("No Redis client, cross-instance invalidation is disabled")
+		return
+	}
+
+	go func() {
+		for msg := range sub.Channel() {
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				cacheLogger.Warn(fmt.Sprintf("Ignoring malformed invalidation: %v", err))
+				continue
+			}
+			if inv.Origin == instanceID {
+				continue
+			}
+			if err := inv.Scope.Validate(); err != nil {
+				cacheLogger.Warn(fmt.Sprintf("Ignoring invalidation from %s: %v", inv.Origin, err))
+				continue
+			}
+			removed := memory.purge(inv.Scope.matches)
+			audit(fmt.Sprintf("📨 Applied flush %s from %s, removed %d local entries", inv.Scope, inv.Origin, removed))
+		}
+	}()
+	cacheLogger.Info(fmt.Sprintf("Listening for invalidations on %s", invalidationChannel()))
+}
+
+func audit(msg string) {
+	if auditLogger != nil {
+		auditLogger.Info(msg)
+	}
+	cacheLogger.Info(msg)
+}
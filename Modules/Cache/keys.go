@@ -0,0 +1,97 @@
+package Cache
+
+// Cache key schema
+//
+// Every key HopZero writes to Redis is namespaced and versioned so several
+// resolver instances or environments can share one Redis without colliding:
+//
+//	<prefix>:v<SchemaVersion>:rr:<qname>:<class>:<type>:<flags>   answer sets
+//	<prefix>:v<SchemaVersion>:dnskey:<zone>                       validated DNSKEYs
+//
+//	prefix   cache.key_prefix from Config.yaml ("hopzero" when left out), e.g. "hopzero-prod"
+//	qname    lower-cased fully qualified name, e.g. "www.example.com."
+//	class    numeric query class, e.g. 1 for IN
+//	type     numeric query type, e.g. 28 for AAAA
+//	flags    "do" and "cd" followed by 0 or 1, e.g. "do1cd0"
+//
+// Values are binary entries (see entry.go). SchemaVersion must be bumped
+// whenever the key layout or the value encoding changes, so old entries are
+// simply never read again and expire on their own.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+)
+
+const SchemaVersion = 2
+
+// namespace returns "<prefix>:v<SchemaVersion>"
+func namespace() string {
+	return fmt.Sprintf("%s:v%d", Loader.Current().Cache.KeyPrefix, SchemaVersion)
+}
+
+// RRKey builds the key for an answer set
+func RRKey(q dns.Question, do, cd bool) string {
+	return fmt.Sprintf("%s:rr:%s:%d:%d:do%dcd%d", namespace(), canonicalName(q.Name), q.Qclass, q.Qtype, bit(do), bit(cd))
+}
+
+// DNSKEYKey builds the key for a validated DNSKEY of a zone
+func DNSKEYKey(zone string) string {
+	return fmt.Sprintf("%s:dnskey:%s", namespace(), canonicalName(zone))
+}
+
+// parseKey splits a key of this instance's namespace into its kind ("rr" or
+// "dnskey"), owner name and type; DNSKEY keys report dns.TypeDNSKEY
+func parseKey(key string) (kind, qname string, qtype uint16, ok bool) {
+	rest, found := strings.CutPrefix(key, namespace()+":")
+	if !found {
+		return "", "", 0, false
+	}
+
+	if zone, found := strings.CutPrefix(rest, "dnskey:"); found {
+		return "dnskey", zone, dns.TypeDNSKEY, true
+	}
+
+	rest, found = strings.CutPrefix(rest, "rr:")
+	if !found {
+		return "", "", 0, false
+	}
+	// qname:class:type:flags, split from the right since only the name is free-form
+	fields := strings.Split(rest, ":")
+	if len(fields) < 4 {
+		return "", "", 0, false
+	}
+	t, err := strconv.ParseUint(fields[len(fields)-2], 10, 16)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return "rr", strings.Join(fields[:len(fields)-3], ":"), uint16(t), true
+}
+
+// globEscape quotes the characters Redis MATCH patterns treat specially
+func globEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
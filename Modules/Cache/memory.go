@@ -0,0 +1,97 @@
+package Cache
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryTier is a bounded per-instance cache in front of Redis
+type memoryTier struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+	limit   int
+}
+
+var memory = &memoryTier{entries: make(map[string]*Entry)}
+
+// configure sets the maximum number of entries, 0 disables the tier
+func (m *memoryTier) configure(limit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limit = limit
+	m.entries = make(map[string]*Entry)
+}
+
+func (m *memoryTier) get(key string) (*Entry, bool) {
+	m.mu.RLock()
+	entry, ok := m.entries[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if entry.Remaining(time.Now()) == 0 {
+		m.mu.Lock()
+		delete(m.entries, key)
+		m.mu.Unlock()
+		return nil, false
+	}
+	return entry, true
+}
+
+func (m *memoryTier) put(key string, entry *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.limit <= 0 {
+		return
+	}
+
+	if _, exists := m.entries[key]; !exists && len(m.entries) >= m.limit {
+		m.evict()
+	}
+	m.entries[key] = entry
+}
+
+// evict drops expired entries, or an arbitrary one if none have expired yet
+func (m *memoryTier) evict() {
+	now := time.Now()
+	for key, entry := range m.entries {
+		if entry.Remaining(now) == 0 {
+			delete(m.entries, key)
+		}
+	}
+	if len(m.entries) < m.limit {
+		return
+	}
+	for key := range m.entries {
+		delete(m.entries, key)
+		return
+	}
+}
+
+// purge removes every entry whose key matches and returns how many were removed
+func (m *memoryTier) purge(match func(key string) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for key := range m.entries {
+		if match(key) {
+			delete(m.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// each calls fn for every live entry
+func (m *memoryTier) each(fn func(key string, entry *Entry)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for key, entry := range m.entries {
+		if entry.Remaining(now) > 0 {
+			fn(key, entry)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package Cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Redis"
+)
+
+// Snapshot file layout, gzip-compressed, big endian:
+//
+//	"HZCS"            magic
+//	uint8             snapshot format (snapshotFormat)
+//	uint8             cache SchemaVersion the keys were written with
+//	int64             created at, unix seconds
+//	uint32            entry count
+//	per entry:
+//	  uint16 + bytes  key without the "<prefix>:v<N>:" namespace
+//	  uint32 + bytes  value in the Entry wire format, so remaining TTLs and DNSSEC status survive
+const (
+	snapshotMagic  = "HZCS"
+	snapshotFormat = 1
+
+	// Largest value Entry.Marshal produces, a header and one DNS message
+	maxEntrySize = entryHeaderSize + dns.MaxMsgSize
+)
+
+// Snapshot is the decoded content of a snapshot file
+type Snapshot struct {
+	Created time.Time
+	Schema  int
+	Entries map[string]*Entry
+}
+
+// collect gathers live entries from the memory tier and, when reachable, from Redis
+func collect() (*Snapshot, error) {
+	snap := &Snapshot{Created: time.Now(), Schema: SchemaVersion, Entries: make(map[string]*Entry)}
+	prefix := namespace() + ":"
+
+	memory.each(func(key string, entry *Entry) {
+		snap.Entries[strings.TrimPrefix(key, prefix)] = entry
+	})
+
+	if !Redis.Available() {
+		return snap, nil
+	}
+	now := time.Now()
+	err := Redis.ScanKeys(globEscape(namespace())+":*", func(keys []string) error {
+		for _, key := range keys {
+			rel := strings.TrimPrefix(key, prefix)
+			if _, seen := snap.Entries[rel]; seen {
+				continue
+			}
+			if _, _, _, ok := parseKey(key); !ok {
+				continue
+			}
+			val, err := Redis.Get(key)
+			if err != nil {
+				continue
+			}
+			if entry, err := UnmarshalEntry(val); err == nil && entry.Remaining(now) > 0 {
+				snap.Entries[rel] = entry
+			}
+		}
+		return nil
+	})
+	return snap, err
+}
+
+// WriteSnapshot saves the current cache to path, replacing any previous file atomically
+func WriteSnapshot(path string) (int, error) {
+	snap, err := collect()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create snapshot dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := snap.encode(tmp); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("failed to move snapshot into place: %w", err)
+	}
+
+	cacheLogger.Info(fmt.Sprintf("💾 Wrote %d cache entries to %s", len(snap.Entries), path))
+	return len(snap.Entries), nil
+}
+
+// ReadSnapshot decodes a snapshot file without touching the cache
+func ReadSnapshot(path string) (*Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	return decodeSnapshot(file)
+}
+
+// LoadSnapshot warms both cache tiers from a snapshot, skipping entries that expired meanwhile
+func LoadSnapshot(path string) (int, error) {
+	snap, err := ReadSnapshot(path)
+	if err != nil {
+		return 0, err
+	}
+	if snap.Schema != SchemaVersion {
+		return 0, fmt.Errorf("snapshot uses cache schema v%d, this build uses v%d", snap.Schema, SchemaVersion)
+	}
+
+	loaded, expired := 0, 0
+	var redisErr error
+	now := time.Now()
+	for rel, entry := range snap.Entries {
+		if entry.Remaining(now) == 0 {
+			expired++
+			continue
+		}
+		if err := Store(namespace()+":"+rel, entry); err != nil && redisErr == nil {
+			redisErr = err
+		}
+		loaded++
+	}
+
+	if redisErr != nil {
+		cacheLogger.Warn(fmt.Sprintf("Snapshot entries only loaded into memory, Redis store failed: %v", redisErr))
+	}
+	cacheLogger.Info(fmt.Sprintf("♻️ Loaded %d cache entries from %s (%d expired since %s)", loaded, path, expired, snap.Created.Format(time.RFC3339)))
+	return loaded, nil
+}
+
+func (s *Snapshot) encode(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+
+	header := make([]byte, 0, 18)
+	header = append(header, snapshotMagic...)
+	header = append(header, snapshotFormat, uint8(s.Schema))
+	header = binary.BigEndian.AppendUint64(header, uint64(s.Created.Unix()))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(s.Entries)))
+	if _, err := bw.Write(header); err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+
+	for key, entry := range s.Entries {
+		val, err := entry.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+		record := make([]byte, 0, 6+len(key)+len(val))
+		record = binary.BigEndian.AppendUint16(record, uint16(len(key)))
+		record = append(record, key...)
+		record = binary.BigEndian.AppendUint32(record, uint32(len(val)))
+		record = append(record, val...)
+		if _, err := bw.Write(record); err != nil {
+			return fmt.Errorf("failed to write snapshot entry: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return zw.Close()
+}
+
+func decodeSnapshot(r io.Reader) (*Snapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a snapshot file: %w", err)
+	}
+	br := bufio.NewReader(zr)
+
+	header := make([]byte, 18)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("truncated snapshot header: %w", err)
+	}
+	if string(header[:4]) != snapshotMagic {
+		return nil, errors.New("not a snapshot file: bad magic")
+	}
+	if header[4] != snapshotFormat {
+		return nil, fmt.Errorf("unsupported snapshot format %d", header[4])
+	}
+
+	snap := &Snapshot{
+		Schema:  int(header[5]),
+		Created: time.Unix(int64(binary.BigEndian.Uint64(header[6:14])), 0),
+		Entries: make(map[string]*Entry),
+	}
+	// count only bounds the loop, nothing is sized from it, so a corrupt one ends in a
+	// truncation error once the data runs out
+	count := binary.BigEndian.Uint32(header[14:18])
+
+	for i := uint32(0); i < count; i++ {
+		var keyLen uint16
+		if err := binary.Read(br, binary.BigEndian, &keyLen); err != nil {
+			return nil, fmt.Errorf("truncated snapshot at entry %d: %w", i, err)
+		}
+		key, err := readFull(br, int(keyLen))
+		if err != nil {
+			return nil, fmt.Errorf("truncated snapshot at entry %d: %w", i, err)
+		}
+		var valLen uint32
+		if err := binary.Read(br, binary.BigEndian, &valLen); err != nil {
+			return nil, fmt.Errorf("truncated snapshot at entry %d: %w", i, err)
+		}
+		if valLen > maxEntrySize {
+			return nil, fmt.Errorf("corrupt snapshot at entry %d: value of %d bytes exceeds %d", i, valLen, maxEntrySize)
+		}
+		val, err := readFull(br, int(valLen))
+		if err != nil {
+			return nil, fmt.Errorf("truncated snapshot at entry %d: %w", i, err)
+		}
+
+		entry, err := UnmarshalEntry(val)
+		if err != nil {
+			return nil, fmt.Errorf("bad entry %s: %w", key, err)
+		}
+		snap.Entries[string(key)] = entry
+	}
+	return snap, nil
+}
+
+// readFull reads n bytes, growing the buffer as data arrives so a corrupt length in the
+// file cannot allocate more than the file actually holds
+func readFull(r io.Reader, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// JSON renders the snapshot for debugging, with remaining TTLs as of now
+func (s *Snapshot) JSON() ([]byte, error) {
+	type jsonEntry struct {
+		Key       string    `json:"key"`
+		StoredAt  time.Time `json:"stored_at"`
+		TTL       uint32    `json:"ttl"`
+		Remaining uint32    `json:"remaining"`
+		Secure    bool      `json:"dnssec_secure"`
+		Rcode     string    `json:"rcode"`
+		Records   []string  `json:"records"`
+	}
+	out := struct {
+		Created time.Time   `json:"created"`
+		Schema  int         `json:"schema_version"`
+		Entries []jsonEntry `json:"entries"`
+	}{Created: s.Created, Schema: s.Schema, Entries: []jsonEntry{}}
+
+	now := time.Now()
+	for key, entry := range s.Entries {
+		records := make([]string, 0, len(entry.Answer))
+		for _, rr := range entry.Answer {
+			records = append(records, rr.String())
+		}
+		out.Entries = append(out.Entries, jsonEntry{
+			Key:       key,
+			StoredAt:  entry.StoredAt,
+			TTL:       entry.TTL,
+			Remaining: entry.Remaining(now),
+			Secure:    entry.Secure,
+			Rcode:     dns.RcodeToString[entry.Rcode],
+			Records:   records,
+		})
+	}
+	sort.Slice(out.Entries, func(i, j int) bool { return out.Entries[i].Key < out.Entries[j].Key })
+	return json.MarshalIndent(out, "", "  ")
+}
@@ -0,0 +1,82 @@
+package Certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+)
+
+var (
+	certsLogger *Logger.ModuleLogger
+
+	// One store per primary pair, so DoT, DoH and DoQ sharing a certificate share its reloads
+	storesMu sync.Mutex
+	stores   = make(map[string]*Store)
+)
+
+func init() {
+	var err error
+	certsLogger, err = Logger.GetLogger("Certs")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Certs module:", err)
+	}
+}
+
+// LoadServerTLSConfig builds the TLS config shared by the encrypted listeners. The given pair
+// is served when no SNI certificate from the config matches; all of them reload without a restart.
+func LoadServerTLSConfig(certPath, keyPath string) (*tls.Config, error) {
+	store, err := storeFor(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		GetCertificate: store.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+func storeFor(certPath, keyPath string) (*Store, error) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	id := certPath + "\x00" + keyPath
+	if store, ok := stores[id]; ok {
+		return store, nil
+	}
+
+	conf := Loader.Current().TLS
+	pairs := []Pair{{CertFile: certPath, KeyFile: keyPath}}
+	for _, extra := range conf.SNICertificates {
+		pairs = append(pairs, Pair{CertFile: extra.CertFile, KeyFile: extra.KeyFile})
+	}
+	store, err := NewStore(pairs)
+	if err != nil {
+		return nil, err
+	}
+	stores[id] = store
+
+	if interval := conf.ReloadInterval; interval > 0 {
+		go store.Watch(interval)
+	}
+	return store, nil
+}
+
+// ReloadAll reloads every certificate store, on SIGHUP or after a renewal hook ran
+func ReloadAll() {
+	storesMu.Lock()
+	all := make([]*Store, 0, len(stores))
+	for _, store := range stores {
+		all = append(all, store)
+	}
+	storesMu.Unlock()
+
+	for _, store := range all {
+		if err := store.Reload(); err != nil {
+			certsLogger.Error("❌ Certificate reload failed, still serving the previous certificates: " + err.Error())
+		}
+	}
+}
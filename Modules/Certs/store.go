@@ -0,0 +1,114 @@
+package Certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+)
+
+var (
+	certReloads        = Metrics.NewCounter("hopzero_tls_reloads_total", "Certificate sets reloaded from disk")
+	certReloadFailures = Metrics.NewCounter("hopzero_tls_reload_failures_total", "Certificate reloads that failed and kept the previous set")
+)
+
+// Pair is a certificate chain and its private key on disk
+type Pair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Store serves a set of certificates by SNI and swaps in renewed files atomically
+type Store struct {
+	pairs []Pair
+
+	mu       sync.Mutex // serializes reloads
+	modTimes []time.Time
+	certs    atomic.Pointer[[]*tls.Certificate]
+}
+
+// NewStore loads every pair, the first one is served when no other certificate matches the client
+func NewStore(pairs []Pair) (*Store, error) {
+	s := &Store{pairs: pairs}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads all pairs again; if any of them fails the previous set stays in place
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	certs := make([]*tls.Certificate, 0, len(s.pairs))
+	modTimes := make([]time.Time, 0, len(s.pairs)*2)
+	for _, pair := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			certReloadFailures.Inc()
+			return fmt.Errorf("failed to load certificate %s: %w", pair.CertFile, err)
+		}
+		certs = append(certs, &cert)
+		modTimes = append(modTimes, modTime(pair.CertFile), modTime(pair.KeyFile))
+	}
+
+	first := s.certs.Swap(&certs) == nil
+	s.modTimes = modTimes
+	if !first {
+		certReloads.Inc()
+		certsLogger.Info(fmt.Sprintf("🔄 Reloaded %d certificate(s), new handshakes use them now", len(certs)))
+	}
+	return nil
+}
+
+// GetCertificate picks the first certificate valid for the client's SNI and signature algorithms
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.certs.Load()
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return certs[0], nil
+}
+
+// Watch polls the files and reloads when any of them changed
+func (s *Store) Watch(interval int) {
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			// Renewal tools may still be writing the files, the next tick tries again
+			certsLogger.Warn("⚠️ Certificate files changed but could not be loaded yet: " + err.Error())
+		}
+	}
+}
+
+func (s *Store) changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, pair := range s.pairs {
+		if !modTime(pair.CertFile).Equal(s.modTimes[i*2]) || !modTime(pair.KeyFile).Equal(s.modTimes[i*2+1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
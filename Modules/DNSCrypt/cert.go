@@ -0,0 +1,240 @@
+package DNSCrypt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Certificate layout (DNSCrypt v2 section 11): magic, es-version, minor
+// version, signature, then the signed part: resolver key, client magic,
+// serial and validity
+const (
+	certSize       = 124
+	signedOffset   = 72
+	clientMagicLen = 8
+)
+
+var certMagic = []byte("DNSC")
+
+// Cert is a resolver certificate signed by the provider key
+type Cert struct {
+	ESVersion   uint16
+	ResolverPK  []byte
+	ClientMagic []byte
+	Serial      uint32
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// resolverKey is a short-term key pair together with the certificate publishing it
+type resolverKey struct {
+	cert   Cert
+	secret []byte
+	raw    []byte // signed wire form served in the TXT record
+}
+
+// keySet is the set of certificates currently served, swapped whole on rotation
+type keySet struct {
+	keys    []*resolverKey
+	byMagic map[string]*resolverKey
+}
+
+// marshal signs the certificate with the provider key
+func (c *Cert) marshal(provider ed25519.PrivateKey) []byte {
+	out := make([]byte, certSize)
+	copy(out, certMagic)
+	binary.BigEndian.PutUint16(out[4:], c.ESVersion)
+	// minor version 0 at out[6:8]
+	signed := out[signedOffset:]
+	copy(signed, c.ResolverPK)
+	copy(signed[32:], c.ClientMagic)
+	binary.BigEndian.PutUint32(signed[40:], c.Serial)
+	binary.BigEndian.PutUint32(signed[44:], uint32(c.NotBefore.Unix()))
+	binary.BigEndian.PutUint32(signed[48:], uint32(c.NotAfter.Unix()))
+	copy(out[8:], ed25519.Sign(provider, signed))
+	return out
+}
+
+// ParseCert checks a certificate's signature against the provider public key
+func ParseCert(raw []byte, provider ed25519.PublicKey) (*Cert, error) {
+	if len(raw) != certSize || string(raw[:4]) != string(certMagic) {
+		return nil, errors.New("not a DNSCrypt certificate")
+	}
+	signed := raw[signedOffset:]
+	if !ed25519.Verify(provider, signed, raw[8:signedOffset]) {
+		return nil, errors.New("certificate signature does not match the provider key")
+	}
+	return &Cert{
+		ESVersion:   binary.BigEndian.Uint16(raw[4:]),
+		ResolverPK:  append([]byte(nil), signed[:32]...),
+		ClientMagic: append([]byte(nil), signed[32:40]...),
+		Serial:      binary.BigEndian.Uint32(signed[40:]),
+		NotBefore:   time.Unix(int64(binary.BigEndian.Uint32(signed[44:])), 0),
+		NotAfter:    time.Unix(int64(binary.BigEndian.Uint32(signed[48:])), 0),
+	}, nil
+}
+
+// Valid reports whether the certificate is inside its validity window at t
+func (c *Cert) Valid(t time.Time) bool {
+	return !t.Before(c.NotBefore) && t.Before(c.NotAfter)
+}
+
+// newResolverKey creates a fresh key pair and certificate for one construction
+func newResolverKey(provider ed25519.PrivateKey, es uint16, serial uint32, now time.Time, ttl time.Duration) (*resolverKey, error) {
+	public, secret, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	cert := Cert{
+		ESVersion:   es,
+		ResolverPK:  public,
+		ClientMagic: public[:clientMagicLen],
+		Serial:      serial,
+		NotBefore:   now,
+		NotAfter:    now.Add(ttl),
+	}
+	return &resolverKey{cert: cert, secret: secret, raw: cert.marshal(provider)}, nil
+}
+
+// certRotator issues new resolver keys on a schedule and keeps the older ones
+// until they expire, so clients holding a previous certificate keep working
+type certRotator struct {
+	provider      ed25519.PrivateKey
+	constructions []uint16
+	ttl           time.Duration
+	current       atomic.Pointer[keySet]
+}
+
+func (r *certRotator) rotate() error {
+	now := time.Now()
+	serial := uint32(now.Unix())
+
+	next := &keySet{byMagic: make(map[string]*resolverKey)}
+	if old := r.current.Load(); old != nil {
+		for _, key := range old.keys {
+			if key.cert.Valid(now) && key.cert.Serial != serial {
+				next.keys = append(next.keys, key)
+			}
+		}
+	}
+	for _, es := range r.constructions {
+		key, err := newResolverKey(r.provider, es, serial, now, r.ttl)
+		if err != nil {
+			return err
+		}
+		next.keys = append(next.keys, key)
+	}
+	for _, key := range next.keys {
+		next.byMagic[string(key.cert.ClientMagic)] = key
+	}
+
+	r.current.Store(next)
+	certRotations.Inc()
+	dnscryptLogger.Info(fmt.Sprintf("🔄 Issued DNSCrypt certificates with serial %d, valid until %s (%d served)",
+		serial, now.Add(r.ttl).Format(time.RFC3339), len(next.keys)))
+	return nil
+}
+
+// run issues fresh certificates every interval until done is closed
+func (r *certRotator) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if err := r.rotate(); err != nil {
+			dnscryptLogger.Error("❌ Failed to rotate DNSCrypt certificates: " + err.Error())
+		}
+	}
+}
+
+// lookup finds the resolver key a query was encrypted for by its client magic
+func (r *certRotator) lookup(magic []byte) *resolverKey {
+	set := r.current.Load()
+	key, ok := set.byMagic[string(magic)]
+	if !ok || !key.cert.Valid(time.Now()) {
+		return nil
+	}
+	return key
+}
+
+// certificates returns the wire form of every certificate still valid
+func (r *certRotator) certificates() [][]byte {
+	now := time.Now()
+	var out [][]byte
+	for _, key := range r.current.Load().keys {
+		if key.cert.Valid(now) {
+			out = append(out, key.raw)
+		}
+	}
+	return out
+}
+
+// GenerateProviderKey writes a new Ed25519 provider key, refusing to replace an existing one
+func GenerateProviderKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadProviderKey reads the Ed25519 provider key written by GenerateProviderKey
+func LoadProviderKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider key in %s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("provider key in %s is not Ed25519", path)
+	}
+	return key, nil
+}
+
+// Fingerprint prints a provider public key the way DNSCrypt clients show it
+func Fingerprint(public ed25519.PublicKey) string {
+	encoded := strings.ToUpper(hex.EncodeToString(public))
+	var groups []string
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, ":")
+}
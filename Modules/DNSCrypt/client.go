@@ -0,0 +1,143 @@
+package DNSCrypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Smallest padded UDP query, so the resolver has room for a useful answer
+const clientMinQuery = 256
+
+// Client talks to a DNSCrypt resolver directly or through an anonymizing relay.
+// It is enough to check a listener over loopback.
+type Client struct {
+	ProviderName string
+	ProviderKey  ed25519.PublicKey
+	Net          string        // "udp" (default) or "tcp"
+	Relay        string        // optional relay address, the server must then be an IP:port
+	Timeout      time.Duration // per exchange, 5s when zero
+}
+
+// FetchCert asks the server for its certificates and returns the newest valid one
+func (c *Client) FetchCert(server string) (*Cert, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(c.ProviderName), dns.TypeTXT)
+	wire, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.roundTrip(server, wire)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(raw); err != nil {
+		return nil, fmt.Errorf("invalid certificate response: %w", err)
+	}
+	// Certificates rarely fit in a UDP answer no larger than the query
+	if resp.Truncated && c.Net != "tcp" {
+		tcp := *c
+		tcp.Net = "tcp"
+		return tcp.FetchCert(server)
+	}
+
+	var best *Cert
+	now := time.Now()
+	for _, rr := range resp.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok || len(txt.Txt) != 1 {
+			continue
+		}
+		cert, err := ParseCert(txtUnescape(txt.Txt[0]), c.ProviderKey)
+		if err != nil || !cert.Valid(now) {
+			continue
+		}
+		if cert.ESVersion != XSalsa20Poly1305 && cert.ESVersion != XChaCha20Poly1305 {
+			continue
+		}
+		// Newest serial wins, XChaCha20 on a tie
+		if best == nil || cert.Serial > best.Serial || (cert.Serial == best.Serial && cert.ESVersion > best.ESVersion) {
+			best = cert
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no valid certificate signed by the provider key")
+	}
+	return best, nil
+}
+
+// Exchange encrypts m for cert, sends it and decrypts the answer
+func (c *Client) Exchange(server string, cert *Cert, m *dns.Msg) (*dns.Msg, error) {
+	public, secret, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	shared, err := sharedKey(cert.ESVersion, secret, cert.ResolverPK)
+	if err != nil {
+		return nil, err
+	}
+	wire, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:halfNonce]); err != nil {
+		return nil, err
+	}
+	minLen := 0
+	if c.Net != "tcp" {
+		minLen = clientMinQuery
+	}
+	packet := append(append(append([]byte{}, cert.ClientMagic...), public...), nonce[:halfNonce]...)
+	packet = append(packet, seal(cert.ESVersion, shared, &nonce, pad(wire, minLen))...)
+
+	raw, err := c.roundTrip(server, packet)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < responseHeader+tagSize || !bytes.Equal(raw[:clientMagicLen], resolverMagic) ||
+		!bytes.Equal(raw[clientMagicLen:clientMagicLen+halfNonce], nonce[:halfNonce]) {
+		return nil, errors.New("response does not belong to this query")
+	}
+	copy(nonce[:], raw[clientMagicLen:responseHeader])
+	plain, err := open(cert.ESVersion, shared, &nonce, raw[responseHeader:])
+	if err == nil {
+		plain, err = unpad(plain)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	return resp, resp.Unpack(plain)
+}
+
+// roundTrip sends one packet, wrapped for the relay if one is set
+func (c *Client) roundTrip(server string, packet []byte) ([]byte, error) {
+	network := c.Net
+	if network == "" {
+		network = "udp"
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = relayTimeout
+	}
+
+	addr := server
+	if c.Relay != "" {
+		target, err := net.ResolveUDPAddr("udp", server)
+		if err != nil || target.IP == nil {
+			return nil, fmt.Errorf("relayed server must be an IP:port, got %q", server)
+		}
+		packet = append(relayPrefix(target), packet...)
+		addr = c.Relay
+	}
+	return exchangeRaw(network, addr, packet, timeout)
+}
@@ -0,0 +1,164 @@
+package DNSCrypt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/poly1305"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// Encryption system versions, the es-version field of a certificate
+const (
+	XSalsa20Poly1305  uint16 = 1
+	XChaCha20Poly1305 uint16 = 2
+)
+
+// Construction names as written in Config.yaml
+var constructions = map[string]uint16{
+	"xsalsa20poly1305":  XSalsa20Poly1305,
+	"xchacha20poly1305": XChaCha20Poly1305,
+}
+
+func constructionName(es uint16) string {
+	for name, v := range constructions {
+		if v == es {
+			return name
+		}
+	}
+	return fmt.Sprintf("es-version %d", es)
+}
+
+const (
+	tagSize   = poly1305.TagSize
+	nonceSize = 24
+	halfNonce = nonceSize / 2
+	keySize   = 32
+
+	// Plain text is padded to a multiple of this (ISO/IEC 7816-4: 0x80 then zeros)
+	padBlock = 64
+)
+
+var errDecrypt = errors.New("message authentication failed")
+
+// sharedKey runs X25519 and derives the symmetric key of the construction,
+// HSalsa20 for XSalsa20-Poly1305 and HChaCha20 for XChaCha20-Poly1305
+func sharedKey(es uint16, secret, public []byte) (*[keySize]byte, error) {
+	shared, err := curve25519.X25519(secret, public)
+	if err != nil {
+		return nil, err
+	}
+
+	key := new([keySize]byte)
+	var zero [16]byte
+	switch es {
+	case XSalsa20Poly1305:
+		var in [keySize]byte
+		copy(in[:], shared)
+		salsa.HSalsa20(key, &zero, &in, &salsa.Sigma)
+	case XChaCha20Poly1305:
+		derived, err := chacha20.HChaCha20(shared, zero[:])
+		if err != nil {
+			return nil, err
+		}
+		copy(key[:], derived)
+	default:
+		return nil, fmt.Errorf("unsupported es-version %d", es)
+	}
+	return key, nil
+}
+
+// seal encrypts msg in the NaCl box layout, the 16 byte tag before the cipher text
+func seal(es uint16, key *[keySize]byte, nonce *[nonceSize]byte, msg []byte) []byte {
+	if es == XSalsa20Poly1305 {
+		return secretbox.Seal(nil, msg, nonce, key)
+	}
+
+	// XChaCha20 in place of XSalsa20 in the secretbox construction: the first
+	// 32 bytes of key stream are the Poly1305 key, the rest encrypts the message
+	out := make([]byte, tagSize+keySize+len(msg))
+	body := out[tagSize:]
+	copy(body[keySize:], msg)
+	stream, _ := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	stream.XORKeyStream(body, body)
+
+	var polyKey [keySize]byte
+	copy(polyKey[:], body[:keySize])
+	var tag [tagSize]byte
+	poly1305.Sum(&tag, body[keySize:], &polyKey)
+
+	copy(out, tag[:])
+	copy(out[tagSize:], body[keySize:])
+	return out[:tagSize+len(msg)]
+}
+
+// open authenticates and decrypts a box produced by seal
+func open(es uint16, key *[keySize]byte, nonce *[nonceSize]byte, box []byte) ([]byte, error) {
+	if len(box) < tagSize {
+		return nil, errDecrypt
+	}
+	if es == XSalsa20Poly1305 {
+		msg, ok := secretbox.Open(nil, box, nonce, key)
+		if !ok {
+			return nil, errDecrypt
+		}
+		return msg, nil
+	}
+
+	body := make([]byte, keySize+len(box)-tagSize)
+	copy(body[keySize:], box[tagSize:])
+	stream, _ := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+
+	var polyKey [keySize]byte
+	stream.XORKeyStream(polyKey[:], polyKey[:])
+	var tag [tagSize]byte
+	copy(tag[:], box[:tagSize])
+	if !poly1305.Verify(&tag, body[keySize:], &polyKey) {
+		return nil, errDecrypt
+	}
+	stream.XORKeyStream(body[keySize:], body[keySize:])
+	return body[keySize:], nil
+}
+
+// pad appends 0x80 and zeros up to a multiple of the block size, at least minLen bytes
+func pad(msg []byte, minLen int) []byte {
+	size := (len(msg) + 1 + padBlock - 1) / padBlock * padBlock
+	if size < minLen {
+		size = (minLen + padBlock - 1) / padBlock * padBlock
+	}
+	out := make([]byte, size)
+	copy(out, msg)
+	out[len(msg)] = 0x80
+	return out
+}
+
+// unpad removes the trailing zeros and the 0x80 marker
+func unpad(msg []byte) ([]byte, error) {
+	i := len(msg) - 1
+	for i >= 0 && msg[i] == 0 {
+		i--
+	}
+	if i < 0 || msg[i] != 0x80 {
+		return nil, errors.New("invalid padding")
+	}
+	return msg[:i], nil
+}
+
+// newKeyPair creates an X25519 key pair for a resolver certificate or a client
+func newKeyPair() (public, secret []byte, err error) {
+	secret = make([]byte, keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, nil, err
+	}
+	public, err = curve25519.X25519(secret, curve25519.Basepoint)
+	return public, secret, err
+}
+
+func equalMagic(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
@@ -0,0 +1,495 @@
+package DNSCrypt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Systemd"
+)
+
+// Encrypted responses start with this instead of a client magic
+var resolverMagic = []byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38}
+
+const (
+	// client magic, client public key, client half nonce
+	queryHeader = clientMagicLen + keySize + halfNonce
+	// resolver magic and full nonce
+	responseHeader = clientMagicLen + nonceSize
+
+	// Smallest padded query a client sends (the padding rule keeps queries at 256 bytes or more)
+	minQueryLen = queryHeader + tagSize + padBlock
+	maxPacket   = 4096
+
+	tcpIdleTimeout = 10 * time.Second
+	// Extra padding blocks picked at random for TCP responses, which have no size bound
+	maxExtraBlocks = 4
+)
+
+var (
+	dnscryptLogger *Logger.ModuleLogger
+
+	queriesTotal  = Metrics.NewCounterVec("hopzero_dnscrypt_queries_total", "DNSCrypt queries answered, by construction", "construction")
+	droppedTotal  = Metrics.NewCounterVec("hopzero_dnscrypt_dropped_total", "DNSCrypt packets dropped, by reason", "reason")
+	certRotations = Metrics.NewCounter("hopzero_dnscrypt_cert_rotations_total", "DNSCrypt resolver certificates issued")
+)
+
+func init() {
+	var err error
+	dnscryptLogger, err = Logger.GetLogger("DNSCrypt")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for DNSCrypt module:", err)
+	}
+}
+
+type DNSCryptServer struct {
+	Addr         string
+	ProviderName string
+	ProviderKey  ed25519.PublicKey
+	View         string
+
+	rotator  *certRotator
+	interval time.Duration
+	done     chan struct{} // closed by Stop to end certificate rotation
+	udp      net.PacketConn
+	tcp      net.Listener
+
+	// UDP packets being answered, the read loop waits while it is full like a Do53 worker pool
+	workers chan struct{}
+
+	// Packets and connections in flight, tracked so Stop can let them finish
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+	conns    map[net.Conn]struct{}
+}
+
+// NewDNSCryptServer loads (or creates) the provider key and issues the first resolver certificates.
+// Its clients belong to view, and at most workers UDP packets are answered at once, 0 for no limit.
+func NewDNSCryptServer(addr, view string, workers int) (*DNSCryptServer, error) {
+	conf := Loader.Current().DNSCrypt
+
+	provider, err := LoadProviderKey(conf.ProviderKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		provider, err = GenerateProviderKey(conf.ProviderKeyFile)
+		if err == nil {
+			dnscryptLogger.Info("🔑 Generated a new DNSCrypt provider key in " + conf.ProviderKeyFile)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("provider key: %w", err)
+	}
+
+	rotator := &certRotator{provider: provider, ttl: time.Duration(conf.CertTTL) * time.Second}
+	for _, name := range conf.Constructions {
+		rotator.constructions = append(rotator.constructions, constructions[name])
+	}
+	if err := rotator.rotate(); err != nil {
+		return nil, err
+	}
+
+	public := provider.Public().(ed25519.PublicKey)
+	dnscryptLogger.Info(fmt.Sprintf("🔐 DNSCrypt provider %s, public key %s", conf.ProviderName, Fingerprint(public)))
+	var pool chan struct{}
+	if workers > 0 {
+		pool = make(chan struct{}, workers)
+	}
+	return &DNSCryptServer{
+		Addr:         addr,
+		ProviderName: dns.Fqdn(strings.ToLower(conf.ProviderName)),
+		ProviderKey:  public,
+		View:         view,
+		rotator:      rotator,
+		interval:     time.Duration(conf.RotationInterval) * time.Second,
+		workers:      pool,
+		done:         make(chan struct{}),
+		conns:        make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Start listens on UDP and TCP, or takes the sockets systemd passed, and serves both in the
+// background until they are closed
+func (s *DNSCryptServer) Start() error {
+	var err error
+	if s.udp, err = Systemd.ListenUDP(s.Addr); err != nil {
+		return err
+	}
+	if s.tcp, err = Systemd.ListenTCP(s.Addr); err != nil {
+		s.udp.Close()
+		return err
+	}
+	go s.rotator.run(s.interval, s.done)
+	go s.serveUDP()
+	go func() {
+		if err := s.serveTCP(); err != nil {
+			dnscryptLogger.Error(fmt.Sprintf("❌ DNSCrypt TCP listener on %s stopped: %v", s.Addr, err))
+		}
+	}()
+	return nil
+}
+
+// Stop stops reading new packets and connections, lets the ones in flight finish until ctx
+// is done, then closes both sockets
+func (s *DNSCryptServer) Stop(ctx context.Context) error {
+	dnscryptLogger.Info("🛑 Stopping DNSCrypt server...")
+	s.mu.Lock()
+	if !s.draining {
+		close(s.done)
+	}
+	s.draining = true
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	if s.udp != nil {
+		_ = s.udp.SetReadDeadline(time.Now())
+	}
+	// Idle TCP clients are waiting for their next query, wake them up
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	return err
+}
+
+// track counts a packet or connection in flight, false once Stop has been called
+func (s *DNSCryptServer) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+func (s *DNSCryptServer) stopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// serveUDP takes a worker before each read, so a burst queues in the socket's kernel buffer
+// instead of piling up goroutines while every worker is busy
+func (s *DNSCryptServer) serveUDP() {
+	for {
+		s.acquire()
+		buf := make([]byte, maxPacket)
+		n, remote, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			s.release()
+			if errors.Is(err, net.ErrClosed) || s.stopping() {
+				return
+			}
+			dnscryptLogger.Warn("⚠️ DNSCrypt UDP read failed: " + err.Error())
+			continue
+		}
+		if !s.track() {
+			s.release()
+			return
+		}
+		go func() {
+			defer s.inflight.Done()
+			defer s.release()
+			if resp := s.handle(buf[:n], remote, false); resp != nil {
+				if _, err := s.udp.WriteTo(resp, remote); err != nil {
+					dnscryptLogger.Warn("⚠️ Failed to send DNSCrypt response: " + err.Error())
+				}
+			}
+		}()
+	}
+}
+
+func (s *DNSCryptServer) acquire() {
+	if s.workers != nil {
+		s.workers <- struct{}{}
+	}
+}
+
+func (s *DNSCryptServer) release() {
+	if s.workers != nil {
+		<-s.workers
+	}
+}
+
+func (s *DNSCryptServer) serveTCP() error {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !s.track() {
+			conn.Close()
+			return nil
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers length-prefixed packets until the client goes quiet
+func (s *DNSCryptServer) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.inflight.Done()
+	}()
+
+	for {
+		// Checked under the lock Stop holds, so a draining deadline is never pushed back
+		s.mu.Lock()
+		draining := s.draining
+		if !draining {
+			_ = conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		}
+		s.mu.Unlock()
+		if draining {
+			return
+		}
+
+		packet, err := readFramed(conn)
+		if err != nil {
+			return
+		}
+		resp := s.handle(packet, conn.RemoteAddr(), true)
+		if resp == nil || writeFramed(conn, resp) != nil {
+			return
+		}
+	}
+}
+
+// handle routes a packet: relayed, encrypted query, or plain certificate request.
+// Anything else is dropped without an answer.
+func (s *DNSCryptServer) handle(packet []byte, remote net.Addr, tcp bool) []byte {
+	switch {
+	case isRelayed(packet):
+		return s.relay(packet, remote, tcp)
+	case len(packet) >= minQueryLen:
+		if key := s.rotator.lookup(packet[:clientMagicLen]); key != nil {
+			return s.answer(key, packet, remote, tcp)
+		}
+	}
+	return s.certResponse(packet, tcp)
+}
+
+// answer decrypts a query, runs it through the pipeline and encrypts the reply
+func (s *DNSCryptServer) answer(key *resolverKey, packet []byte, remote net.Addr, tcp bool) []byte {
+	es := key.cert.ESVersion
+	shared, err := sharedKey(es, key.secret, packet[clientMagicLen:clientMagicLen+keySize])
+	if err != nil {
+		droppedTotal.With("bad_key").Inc()
+		return nil
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], packet[clientMagicLen+keySize:queryHeader])
+
+	plain, err := open(es, shared, &nonce, packet[queryHeader:])
+	if err == nil {
+		plain, err = unpad(plain)
+	}
+	if err != nil {
+		droppedTotal.With("decrypt").Inc()
+		return nil
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(plain); err != nil {
+		droppedTotal.With("malformed").Inc()
+		return nil
+	}
+
+	resp := Pipeline.Serve(Access.Anonymous(remote).InView(s.View), query)
+	limit := maxPacket
+	if !tcp {
+		// A UDP response never exceeds the query, DNSCrypt's amplification rule
+		limit = (len(packet)-responseHeader-tagSize)/padBlock*padBlock - 1
+	}
+	wire, err := fit(query, resp, limit)
+	if err != nil {
+		droppedTotal.With("too_large").Inc()
+		return nil
+	}
+
+	if _, err := rand.Read(nonce[halfNonce:]); err != nil {
+		return nil
+	}
+	minLen := 0
+	if tcp {
+		minLen = len(wire) + 1 + randomBlocks()*padBlock
+	}
+	out := append(append([]byte{}, resolverMagic...), nonce[:]...)
+	out = append(out, seal(es, shared, &nonce, pad(wire, minLen))...)
+	queriesTotal.With(constructionName(es)).Inc()
+	return out
+}
+
+// fit packs resp within limit bytes, falling back to an empty truncated reply
+func fit(query, resp *dns.Msg, limit int) ([]byte, error) {
+	wire, err := resp.Pack()
+	if err != nil || len(wire) <= limit {
+		return wire, err
+	}
+	resp.Truncate(limit)
+	if wire, err = resp.Pack(); err == nil && len(wire) <= limit {
+		return wire, nil
+	}
+
+	tc := new(dns.Msg)
+	tc.SetReply(query)
+	tc.RecursionAvailable = true
+	tc.Truncated = true
+	if wire, err = tc.Pack(); err == nil && len(wire) > limit {
+		err = errors.New("truncated reply still exceeds the query size")
+	}
+	return wire, err
+}
+
+// certResponse answers the plain TXT query for the provider name with the current certificates.
+// Over UDP the answer may not be larger than the query, otherwise it is sent empty with TC set
+// so the client asks again over TCP.
+func (s *DNSCryptServer) certResponse(packet []byte, tcp bool) []byte {
+	query := new(dns.Msg)
+	if err := query.Unpack(packet); err != nil || query.Response || len(query.Question) != 1 {
+		droppedTotal.With("unknown").Inc()
+		return nil
+	}
+	q := query.Question[0]
+	if q.Qtype != dns.TypeTXT || strings.ToLower(q.Name) != s.ProviderName {
+		droppedTotal.With("unknown").Inc()
+		return nil
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(query)
+	m.Authoritative = true
+	for _, raw := range s.rotator.certificates() {
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(s.interval.Seconds())},
+			Txt: []string{txtEscape(raw)},
+		})
+	}
+	wire, err := m.Pack()
+	if err == nil && !tcp && len(wire) > len(packet) {
+		m.Answer = nil
+		m.Truncated = true
+		wire, err = m.Pack()
+	}
+	if err != nil {
+		dnscryptLogger.Warn("⚠️ Failed to pack DNSCrypt certificates: " + err.Error())
+		return nil
+	}
+	return wire
+}
+
+func randomBlocks() int {
+	n, err := rand.Int(rand.Reader, big.NewInt(maxExtraBlocks+1))
+	if err != nil {
+		return 0
+	}
+	return int(n.Int64())
+}
+
+func readFramed(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func writeFramed(w io.Writer, packet []byte) error {
+	out := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(out, uint16(len(packet)))
+	copy(out[2:], packet)
+	_, err := w.Write(out)
+	return err
+}
+
+// txtEscape writes binary data as a TXT string, miekg/dns decodes \DDD when packing
+func txtEscape(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		if b < ' ' || b > '~' || b == '"' || b == '\\' || b == ';' {
+			fmt.Fprintf(&sb, "\\%03d", b)
+		} else {
+			sb.WriteByte(b)
+		}
+	}
+	return sb.String()
+}
+
+// txtUnescape reverses the presentation format miekg/dns uses for unpacked TXT strings
+func txtUnescape(s string) []byte {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			out = append(out, byte(n))
+			i += 3
+			continue
+		}
+		out = append(out, s[i+1])
+		i++
+	}
+	return out
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+// Stamp builds the sdns:// stamp a DNSCrypt client needs to reach addr
+func Stamp(addr, providerName string, public ed25519.PublicKey) string {
+	addr = strings.TrimSuffix(addr, ":443")
+	// Properties: DNSSEC validated, no filtering. Queries are logged, so no "no logs" flag.
+	stamp := []byte{0x01, 0x05, 0, 0, 0, 0, 0, 0, 0}
+	for _, field := range [][]byte{[]byte(addr), public, []byte(strings.TrimSuffix(providerName, "."))} {
+		stamp = append(stamp, byte(len(field)))
+		stamp = append(stamp, field...)
+	}
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(stamp)
+}
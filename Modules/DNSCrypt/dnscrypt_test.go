@@ -0,0 +1,196 @@
+package DNSCrypt
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+)
+
+const testProvider = "2.dnscrypt-cert.hopzero.test"
+
+// startServer runs a DNSCrypt listener on loopback offering the given constructions, with
+// UDP and TCP on the same port so a truncated certificate answer can be retried over TCP
+func startServer(t *testing.T, constructions ...string) (*DNSCryptServer, string) {
+	t.Helper()
+	Loader.Use(testConfig(t, constructions...))
+	srv := serve(t, freeAddr(t))
+	return srv, srv.Addr
+}
+
+// testConfig is a DNSCrypt section with a fresh provider key, the caller passes it to Loader.Use
+func testConfig(t *testing.T, constructions ...string) *Loader.Config {
+	t.Helper()
+	conf := new(Loader.Config)
+	conf.DNSCrypt.ProviderName = testProvider
+	conf.DNSCrypt.ProviderKeyFile = filepath.Join(t.TempDir(), "provider.pem")
+	conf.DNSCrypt.Constructions = constructions
+	conf.DNSCrypt.CertTTL = 3600
+	conf.DNSCrypt.RotationInterval = 3600
+	return conf
+}
+
+// serve starts a server on addr with the current config and stops it when the test ends
+func serve(t *testing.T, addr string) *DNSCryptServer {
+	t.Helper()
+	srv, err := NewDNSCryptServer(addr, "", 4)
+	if err != nil {
+		t.Fatalf("NewDNSCryptServer: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+	})
+	return srv
+}
+
+// freeAddr finds a loopback port that is free for TCP, UDP on it is almost always free too
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// A query outside resolver.arpa would need the network, this one is answered locally
+func localQuery() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("_dns.resolver.arpa.", dns.TypeSVCB)
+	return m
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, es := range constructions {
+		t.Run(name, func(t *testing.T) {
+			srv, addr := startServer(t, name)
+			for _, network := range []string{"udp", "tcp"} {
+				client := &Client{ProviderName: testProvider, ProviderKey: srv.ProviderKey, Net: network, Timeout: 2 * time.Second}
+				cert, err := client.FetchCert(addr)
+				if err != nil {
+					t.Fatalf("%s: FetchCert: %v", network, err)
+				}
+				if cert.ESVersion != es {
+					t.Fatalf("%s: certificate for construction %d, want %d", network, cert.ESVersion, es)
+				}
+
+				query := localQuery()
+				resp, err := client.Exchange(addr, cert, query)
+				if err != nil {
+					t.Fatalf("%s: Exchange: %v", network, err)
+				}
+				if resp.Id != query.Id || resp.Rcode != dns.RcodeSuccess {
+					t.Errorf("%s: got ID %d rcode %s, want ID %d NOERROR", network, resp.Id, dns.RcodeToString[resp.Rcode], query.Id)
+				}
+			}
+		})
+	}
+}
+
+func TestRelay(t *testing.T) {
+	// Both servers read the one global config, which allows relaying to the target only
+	conf := testConfig(t, "xchacha20poly1305")
+	targetAddr := freeAddr(t)
+	conf.DNSCrypt.Relay.Enabled = true
+	conf.DNSCrypt.Relay.AllowedTargets = []string{targetAddr}
+	Loader.Use(conf)
+	target := serve(t, targetAddr)
+	relay := serve(t, freeAddr(t))
+
+	for _, network := range []string{"udp", "tcp"} {
+		client := &Client{ProviderName: testProvider, ProviderKey: target.ProviderKey, Net: network, Timeout: 2 * time.Second, Relay: relay.Addr}
+		cert, err := client.FetchCert(targetAddr)
+		if err != nil {
+			t.Fatalf("%s: FetchCert through the relay: %v", network, err)
+		}
+		query := localQuery()
+		resp, err := client.Exchange(targetAddr, cert, query)
+		if err != nil {
+			t.Fatalf("%s: Exchange through the relay: %v", network, err)
+		}
+		if resp.Id != query.Id || resp.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: got ID %d rcode %s, want ID %d NOERROR", network, resp.Id, dns.RcodeToString[resp.Rcode], query.Id)
+		}
+
+		// The relay is a DNSCrypt server too, but not on its own allow-list
+		client.Timeout = 300 * time.Millisecond
+		if _, err := client.FetchCert(relay.Addr); err == nil {
+			t.Errorf("%s: relay answered for a target that is not allowed", network)
+		}
+	}
+
+	// A relay header inside a relayed packet is never forwarded a second hop, even when the
+	// packet is long enough to pass for an encrypted query
+	to, err := net.ResolveUDPAddr("udp", targetAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(testProvider), dns.TypeTXT)
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	query.IsEdns0().Option = append(query.IsEdns0().Option, &dns.EDNS0_PADDING{Padding: make([]byte, minQueryLen)})
+	packet, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := append(relayPrefix(to), append(relayPrefix(to), packet...)...)
+	if _, err := exchangeRaw("tcp", relay.Addr, nested, 300*time.Millisecond); err == nil {
+		t.Error("relay answered a packet with a nested relay header")
+	}
+}
+
+func TestCertResponseOverUDPIsNoLargerThanTheQuery(t *testing.T) {
+	_, addr := startServer(t, "xchacha20poly1305", "xsalsa20poly1305")
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(testProvider), dns.TypeTXT)
+	small, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := exchangeCert(t, "udp", addr, small)
+	if !resp.Truncated || len(resp.Answer) != 0 {
+		t.Errorf("small query over UDP got TC=%t with %d answers, want an empty truncated reply", resp.Truncated, len(resp.Answer))
+	}
+
+	// A query padded to 1024 bytes leaves room for both certificates
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	query.IsEdns0().Option = append(query.IsEdns0().Option, &dns.EDNS0_PADDING{Padding: make([]byte, 1024-query.Len()-4)})
+	padded, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := exchangeCert(t, "udp", addr, padded); resp.Truncated || len(resp.Answer) != 2 {
+		t.Errorf("padded query over UDP got TC=%t with %d answers, want 2 certificates", resp.Truncated, len(resp.Answer))
+	}
+
+	if resp := exchangeCert(t, "tcp", addr, small); resp.Truncated || len(resp.Answer) != 2 {
+		t.Errorf("small query over TCP got TC=%t with %d answers, want 2 certificates", resp.Truncated, len(resp.Answer))
+	}
+}
+
+func exchangeCert(t *testing.T, network, addr string, packet []byte) *dns.Msg {
+	t.Helper()
+	raw, err := exchangeRaw(network, addr, packet, 2*time.Second)
+	if err != nil {
+		t.Fatalf("%s: %v", network, err)
+	}
+	if network == "udp" && len(raw) > len(packet) {
+		t.Errorf("%d byte answer to a %d byte query over UDP", len(raw), len(packet))
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(raw); err != nil {
+		t.Fatalf("%s: %v", network, err)
+	}
+	return resp
+}
@@ -0,0 +1,130 @@
+package DNSCrypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+)
+
+// Anonymized DNS: a client prefixes its packet with this magic and the
+// target's address, the relay strips both and forwards the rest, so the
+// target never learns the client's address and the relay never sees the query
+var anonMagic = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00}
+
+const (
+	relayHeader  = 10 + 16 + 2 // magic, IPv6 (or v4-mapped) address, port
+	relayTimeout = 5 * time.Second
+)
+
+var relayedTotal = Metrics.NewCounterVec("hopzero_dnscrypt_relayed_total", "Anonymized DNS packets handled as a relay, by result", "result")
+
+func isRelayed(packet []byte) bool {
+	return len(packet) >= relayHeader && bytes.Equal(packet[:len(anonMagic)], anonMagic)
+}
+
+// relayPrefix builds the header that asks a relay to forward to target
+func relayPrefix(target *net.UDPAddr) []byte {
+	prefix := append([]byte{}, anonMagic...)
+	prefix = append(prefix, target.IP.To16()...)
+	return binary.BigEndian.AppendUint16(prefix, uint16(target.Port))
+}
+
+// relay forwards an anonymized packet to an allowed target over the same transport
+func (s *DNSCryptServer) relay(packet []byte, remote net.Addr, tcp bool) []byte {
+	conf := Loader.Current().DNSCrypt.Relay
+	if !conf.Enabled {
+		relayedTotal.With("disabled").Inc()
+		return nil
+	}
+
+	ip := net.IP(packet[len(anonMagic) : len(anonMagic)+16])
+	port := binary.BigEndian.Uint16(packet[relayHeader-2 : relayHeader])
+	target := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	inner := packet[relayHeader:]
+
+	if !relayAllowed(target, conf.AllowedTargets) {
+		relayedTotal.With("target_denied").Inc()
+		dnscryptLogger.Warn(fmt.Sprintf("🚫 Refused to relay for %s to %s, not an allowed target", remote, target))
+		return nil
+	}
+	// Only DNSCrypt queries and certificate requests are relayed, never another relay hop
+	if isRelayed(inner) || !(len(inner) >= minQueryLen || isCertQuery(inner)) {
+		relayedTotal.With("invalid").Inc()
+		return nil
+	}
+
+	network := "udp"
+	if tcp {
+		network = "tcp"
+	}
+	resp, err := exchangeRaw(network, target, inner, relayTimeout)
+	if err != nil {
+		relayedTotal.With("upstream_error").Inc()
+		dnscryptLogger.Warn(fmt.Sprintf("⚠️ Relay to %s failed: %v", target, err))
+		return nil
+	}
+	if !bytes.HasPrefix(resp, resolverMagic) && !isCertResponse(resp) {
+		relayedTotal.With("invalid").Inc()
+		return nil
+	}
+	relayedTotal.With("ok").Inc()
+	return resp
+}
+
+func relayAllowed(target string, allowed []string) bool {
+	for _, entry := range allowed {
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && net.JoinHostPort(ip.String(), port) == target {
+			return true
+		}
+	}
+	return false
+}
+
+func isCertQuery(packet []byte) bool {
+	m := new(dns.Msg)
+	return m.Unpack(packet) == nil && !m.Response && len(m.Question) == 1 &&
+		m.Question[0].Qtype == dns.TypeTXT && strings.HasPrefix(strings.ToLower(m.Question[0].Name), "2.dnscrypt-cert.")
+}
+
+func isCertResponse(packet []byte) bool {
+	m := new(dns.Msg)
+	return m.Unpack(packet) == nil && m.Response && len(m.Question) == 1 && m.Question[0].Qtype == dns.TypeTXT
+}
+
+// exchangeRaw sends one packet and reads one packet back, framed on TCP
+func exchangeRaw(network, addr string, packet []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if network == "tcp" {
+		if err := writeFramed(conn, packet); err != nil {
+			return nil, err
+		}
+		return readFramed(conn)
+	}
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxPacket)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
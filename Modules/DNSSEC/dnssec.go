@@ -2,15 +2,13 @@ package DNSSEC
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Cache"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
-	RedisModule "github.com/official-biswadeb941/HopZero-DNS/Modules/Redis"
 )
 
 var (
@@ -19,12 +17,6 @@ var (
 	DNSSECEnforced  = true // 🔐 Enforce DNSSEC validation strictly
 )
 
-type CachedDNSKEY struct {
-	RR       string    `json:"rr"`
-	CachedAt time.Time `json:"cached_at"`
-	TTL      uint32    `json:"ttl"`
-}
-
 func init() {
 	var err error
 	dnssecLogger, err = Logger.GetLogger("DNSSEC_Logs.log")
@@ -106,28 +98,19 @@ func Validate(msg *dns.Msg) bool {
 	}
 
 	zoneName := msg.Question[0].Name
-	cacheKey := fmt.Sprintf("dnskey:%s", zoneName)
-
-	cachedVal, err := RedisModule.RedisClient.Get(RedisModule.Ctx, cacheKey).Result()
-	if err == nil {
-		var cached CachedDNSKEY
-		err := json.Unmarshal([]byte(cachedVal), &cached)
-		if err == nil {
-			rr, err := dns.NewRR(cached.RR)
-			if err == nil {
-				dnskey, ok := rr.(*dns.DNSKEY)
-				if ok {
+	cacheKey := Cache.DNSKEYKey(zoneName)
+
+	if cached, ok := Cache.Lookup(cacheKey); ok && len(cached.Answer) > 0 {
+		if dnskey, ok := cached.Answer[0].(*dns.DNSKEY); ok {
+			if dnssecLogger != nil {
+				dnssecLogger.Info("Using cached DNSKEY from Redis.")
+			}
+			if dnskey.KeyTag() == rrsigRR.KeyTag {
+				if err := rrsigRR.Verify(dnskey, dnskeyRRs); err == nil {
 					if dnssecLogger != nil {
-						dnssecLogger.Info("Using cached DNSKEY from Redis.")
-					}
-					if dnskey.KeyTag() == rrsigRR.KeyTag {
-						if err := rrsigRR.Verify(dnskey, dnskeyRRs); err == nil {
-							if dnssecLogger != nil {
-								dnssecLogger.Info("DNSSEC verified with cached key ✅")
-							}
-							return true
-						}
+						dnssecLogger.Info("DNSSEC verified with cached key ✅")
 					}
+					return true
 				}
 			}
 		}
@@ -144,13 +127,7 @@ func Validate(msg *dns.Msg) bool {
 						dnssecLogger.Info(fmt.Sprintf("RRSIG validated with DNSKEY tag=%d", dnskey.KeyTag()))
 					}
 
-					rrStr := dnskey.String()
-					jsonVal, _ := json.Marshal(CachedDNSKEY{
-						RR:       rrStr,
-						CachedAt: time.Now(),
-						TTL:      dnskey.Hdr.Ttl,
-					})
-					err := RedisModule.RedisClient.Set(RedisModule.Ctx, cacheKey, jsonVal, time.Duration(dnskey.Hdr.Ttl)*time.Second).Err()
+					err := Cache.Store(cacheKey, Cache.NewEntry([]dns.RR{dnskey}, dns.RcodeSuccess, true))
 					if err != nil && dnssecLogger != nil {
 						dnssecLogger.Warn(fmt.Sprintf("Failed to cache DNSKEY in Redis: %v", err))
 					} else if dnssecLogger != nil {
@@ -0,0 +1,177 @@
+package Discovery
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+)
+
+// Special-use zone answered locally and never forwarded (RFC 9462 section 6.4)
+const (
+	resolverArpa = "resolver.arpa."
+	ddrName      = "_dns.resolver.arpa."
+)
+
+// Encrypted protocols in the order they are advertised, lowest SvcPriority first
+const (
+	ProtocolDoT = "dot"
+	ProtocolDoH = "doh"
+	ProtocolDoQ = "doq"
+)
+
+var preference = map[string]int{ProtocolDoT: 1, ProtocolDoH: 2, ProtocolDoQ: 3}
+
+// Endpoint is one encrypted listener clients can upgrade to
+type Endpoint struct {
+	Protocol string
+	Port     uint16
+	Path     string // DoH only
+}
+
+var (
+	discoveryLogger *Logger.ModuleLogger
+
+	mu        sync.RWMutex
+	endpoints = make(map[string]Endpoint) // by protocol and bind address, one per listener
+)
+
+func init() {
+	var err error
+	discoveryLogger, err = Logger.GetLogger("Discovery")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Discovery module:", err)
+	}
+}
+
+// Register advertises a listener, addr is its bind address such as ":853". Listeners bound
+// to loopback or restricted to a view are not reachable by every client asking, so they
+// are left out.
+func Register(protocol, addr, path, view string) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		discoveryLogger.Warn(fmt.Sprintf("⚠️ Not advertising %s listener %s: %v", protocol, addr, err))
+		return
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		discoveryLogger.Warn(fmt.Sprintf("⚠️ Not advertising %s listener %s: invalid port", protocol, addr))
+		return
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		discoveryLogger.Info(fmt.Sprintf("🔕 Not advertising %s listener %s: bound to loopback", protocol, addr))
+		return
+	}
+	if view != "" {
+		discoveryLogger.Info(fmt.Sprintf("🔕 Not advertising %s listener %s: restricted to view %s", protocol, addr, view))
+		return
+	}
+
+	mu.Lock()
+	endpoints[protocol+" "+addr] = Endpoint{Protocol: protocol, Port: uint16(port), Path: path}
+	mu.Unlock()
+	discoveryLogger.Info(fmt.Sprintf("📣 Advertising %s on port %d for designated resolver discovery", protocol, port))
+}
+
+// Answer serves resolver.arpa and _dns.<target> locally. handled is false for every other name.
+func Answer(q dns.Question) (answer, extra []dns.RR, rcode int, handled bool) {
+	conf := Loader.Current().Discovery
+	name := strings.ToLower(q.Name)
+	target := strings.ToLower(dns.Fqdn(conf.Target))
+	resolverName := conf.Enabled && conf.Target != "" && name == "_dns."+target
+
+	if !dns.IsSubDomain(resolverArpa, name) && !resolverName {
+		return nil, nil, 0, false
+	}
+	if name != ddrName && !resolverName {
+		return nil, nil, dns.RcodeNameError, true
+	}
+	if !conf.Enabled || q.Qtype != dns.TypeSVCB {
+		return nil, nil, dns.RcodeSuccess, true
+	}
+	return records(q.Name, conf), hints(conf), dns.RcodeSuccess, true
+}
+
+// records builds one SVCB record per advertised endpoint (RFC 9461). Listeners on several
+// addresses with the same port look alike to a client and share one record.
+func records(owner string, conf Loader.Discovery) []dns.RR {
+	mu.RLock()
+	seen := make(map[Endpoint]bool, len(endpoints))
+	list := make([]Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if !seen[ep] {
+			seen[ep] = true
+			list = append(list, ep)
+		}
+	}
+	mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Protocol != list[j].Protocol {
+			return preference[list[i].Protocol] < preference[list[j].Protocol]
+		}
+		if list[i].Port != list[j].Port {
+			return list[i].Port < list[j].Port
+		}
+		return list[i].Path < list[j].Path
+	})
+
+	ttl := uint32(conf.TTL)
+	v4, v6 := parseIPs(conf.IPv4Hints), parseIPs(conf.IPv6Hints)
+	var rrs []dns.RR
+	for i, ep := range list {
+		svcb := &dns.SVCB{
+			Hdr:      dns.RR_Header{Name: owner, Rrtype: dns.TypeSVCB, Class: dns.ClassINET, Ttl: ttl},
+			Priority: uint16(i + 1),
+			Target:   dns.Fqdn(conf.Target),
+		}
+		switch ep.Protocol {
+		case ProtocolDoT:
+			svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: []string{"dot"}})
+		case ProtocolDoH:
+			svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: []string{"h2"}})
+		case ProtocolDoQ:
+			svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: []string{"doq"}})
+		}
+		svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: ep.Port})
+		if len(v4) > 0 {
+			svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: v4})
+		}
+		if len(v6) > 0 {
+			svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: v6})
+		}
+		if ep.Protocol == ProtocolDoH {
+			svcb.Value = append(svcb.Value, &dns.SVCBDoHPath{Template: ep.Path + "{?dns}"})
+		}
+		rrs = append(rrs, svcb)
+	}
+	return rrs
+}
+
+// hints returns address records for the target so clients need no extra lookup
+func hints(conf Loader.Discovery) []dns.RR {
+	ttl := uint32(conf.TTL)
+	var rrs []dns.RR
+	for _, ip := range parseIPs(conf.IPv4Hints) {
+		rrs = append(rrs, &dns.A{Hdr: dns.RR_Header{Name: dns.Fqdn(conf.Target), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: ip})
+	}
+	for _, ip := range parseIPs(conf.IPv6Hints) {
+		rrs = append(rrs, &dns.AAAA{Hdr: dns.RR_Header{Name: dns.Fqdn(conf.Target), Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: ip})
+	}
+	return rrs
+}
+
+func parseIPs(list []string) []net.IP {
+	var ips []net.IP
+	for _, s := range list {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,136 @@
+package Do53
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/ProxyProtocol"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Systemd"
+)
+
+var do53Logger *Logger.ModuleLogger
+
+func init() {
+	var err error
+	do53Logger, err = Logger.GetLogger("Do53")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Do53 module:", err)
+	}
+}
+
+// Do53Server is a plain DNS listener on one transport, udp or tcp. A udp listener may
+// spread over several SO_REUSEPORT sockets, each served by its own dns.Server.
+type Do53Server struct {
+	Net     string
+	Addr    string
+	Sockets int // UDP sockets sharing the port, 0 for one per GOMAXPROCS
+	Workers int // Queries in flight per UDP socket, 0 for no limit
+	Handler dns.Handler
+	Servers []*dns.Server
+}
+
+// NewDo53Server prepares a server for network ("udp" or "tcp") on addr that passes every
+// query to handler. sockets and workers only apply to udp.
+func NewDo53Server(network, addr string, sockets, workers int, handler dns.Handler) *Do53Server {
+	if network != "udp" {
+		sockets, workers = 1, 0
+	} else if sockets <= 0 {
+		sockets = runtime.GOMAXPROCS(0)
+	}
+	return &Do53Server{
+		Net:     network,
+		Addr:    addr,
+		Sockets: sockets,
+		Workers: workers,
+		Handler: handler,
+	}
+}
+
+// Start binds the sockets, or takes the ones systemd passed, and serves in the background,
+// returning once they are listening. A TCP listener takes PROXY headers from trusted load balancers.
+func (d *Do53Server) Start() error {
+	if d.Net == "tcp" {
+		tcp, err := ProxyProtocol.Listen(d.Addr)
+		if err != nil {
+			return fmt.Errorf("tcp listener on %s: %w", d.Addr, err)
+		}
+		if err := d.serve(&dns.Server{Addr: d.Addr, Net: d.Net, Handler: d.Handler, Listener: tcp}); err != nil {
+			return err
+		}
+		do53Logger.Info(fmt.Sprintf("📡 Serving DNS over tcp on %s", d.Addr))
+		return nil
+	}
+
+	conns, err := Systemd.ListenUDPReusePort(d.Addr, d.Sockets)
+	if err != nil {
+		return fmt.Errorf("udp listener on %s: %w", d.Addr, err)
+	}
+	for i, conn := range conns {
+		srv := &dns.Server{Addr: d.Addr, Net: d.Net, Handler: d.Handler, PacketConn: conn}
+		if d.Workers > 0 {
+			newWorkerPool(d.Workers).attach(srv)
+		}
+		if err := d.serve(srv); err != nil {
+			for _, rest := range conns[i:] {
+				rest.Close()
+			}
+			d.Stop(context.Background())
+			return err
+		}
+	}
+	d.Sockets = len(conns)
+
+	workers := "unlimited"
+	if d.Workers > 0 {
+		workers = fmt.Sprintf("%d", d.Workers)
+	}
+	do53Logger.Info(fmt.Sprintf("📡 Serving DNS over udp on %s with %d socket(s), %s workers each", d.Addr, len(conns), workers))
+	return nil
+}
+
+// serve runs srv in the background once it reports being started
+func (d *Do53Server) serve(srv *dns.Server) error {
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	errs := make(chan error, 1)
+	go func() {
+		if err := srv.ActivateAndServe(); err != nil {
+			errs <- fmt.Errorf("%s listener on %s: %w", d.Net, d.Addr, err)
+		}
+	}()
+
+	select {
+	case <-started:
+	case err := <-errs:
+		return err
+	}
+	d.Servers = append(d.Servers, srv)
+	go func() {
+		if err := <-errs; err != nil {
+			do53Logger.Error(err.Error())
+		}
+	}()
+	return nil
+}
+
+// Stop shuts down every socket, in-flight queries get until ctx is done to finish
+func (d *Do53Server) Stop(ctx context.Context) error {
+	do53Logger.Info(fmt.Sprintf("Stopping DNS over %s on %s...", d.Net, d.Addr))
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(d.Servers))
+	for i, srv := range d.Servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.ShutdownContext(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,94 @@
+package Do53
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fixedAnswer replies to every query with one A record, so only the listener is measured
+var fixedAnswer = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.IPv4(192, 0, 2, 1),
+	})
+	_ = w.WriteMsg(m)
+})
+
+// BenchmarkUDPReusePort measures queries answered per second over loopback for each number of
+// SO_REUSEPORT sockets up to GOMAXPROCS. Every parallel client has its own socket, so the
+// kernel spreads them over the server's sockets. Run with -cpu to vary GOMAXPROCS too.
+func BenchmarkUDPReusePort(b *testing.B) {
+	var counts []int
+	for n := 1; n < runtime.GOMAXPROCS(0); n *= 2 {
+		counts = append(counts, n)
+	}
+	counts = append(counts, runtime.GOMAXPROCS(0))
+
+	for _, sockets := range counts {
+		b.Run(fmt.Sprintf("sockets=%d", sockets), func(b *testing.B) {
+			srv := NewDo53Server("udp", "127.0.0.1:0", sockets, 1024, fixedAnswer)
+			if err := srv.Start(); err != nil {
+				b.Fatal(err)
+			}
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_ = srv.Stop(ctx)
+			}()
+			addr := srv.Servers[0].PacketConn.LocalAddr().(*net.UDPAddr)
+
+			query := new(dns.Msg)
+			query.SetQuestion("bench.hopzero.test.", dns.TypeA)
+			wire, err := query.Pack()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var lost atomic.Int64
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := net.DialUDP("udp", nil, addr)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				defer conn.Close()
+				msg := append([]byte{}, wire...)
+				buf := make([]byte, dns.MinMsgSize)
+				for id := uint16(0); pb.Next(); id++ {
+					binary.BigEndian.PutUint16(msg, id)
+					_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+					if _, err := conn.Write(msg); err != nil {
+						b.Error(err)
+						return
+					}
+					// Skip late answers to earlier, already lost queries
+					for {
+						n, err := conn.Read(buf)
+						if err != nil {
+							lost.Add(1)
+							break
+						}
+						if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+							break
+						}
+					}
+				}
+			})
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "queries/s")
+			b.ReportMetric(float64(lost.Load()), "lost")
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package Do53
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// workerPool bounds the queries in flight on one UDP socket. While every worker is busy
+// the socket's read loop waits, so a burst queues in that socket's kernel buffer instead
+// of piling up goroutines, and the kernel drops what does not fit.
+type workerPool chan struct{}
+
+func newWorkerPool(workers int) workerPool {
+	return make(workerPool, workers)
+}
+
+// attach makes srv take a worker before each read and give it back once the packet is
+// done with: answered by the handler, rejected by the accept check or found malformed
+func (p workerPool) attach(srv *dns.Server) {
+	handler := srv.Handler
+	srv.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		defer p.release()
+		handler.ServeDNS(w, r)
+	})
+	srv.MsgAcceptFunc = func(dh dns.Header) dns.MsgAcceptAction {
+		action := dns.DefaultMsgAcceptFunc(dh)
+		if action != dns.MsgAccept {
+			p.release()
+		}
+		return action
+	}
+	// Called for short and unparsable packets, which never reach the handler
+	srv.MsgInvalidFunc = func([]byte, error) { p.release() }
+	srv.DecorateReader = func(r dns.Reader) dns.Reader { return poolReader{Reader: r, pool: p} }
+}
+
+func (p workerPool) acquire() { p <- struct{}{} }
+func (p workerPool) release() { <-p }
+
+// poolReader waits for a free worker before reading the next packet
+type poolReader struct {
+	dns.Reader
+	pool workerPool
+}
+
+func (r poolReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	r.pool.acquire()
+	m, session, err := r.Reader.ReadUDP(conn, timeout)
+	if err != nil {
+		r.pool.release()
+	}
+	return m, session, err
+}
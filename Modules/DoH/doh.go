@@ -0,0 +1,236 @@
+package DoH
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Certs"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Discovery"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/ODoH"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/ProxyProtocol"
+)
+
+const (
+	DefaultPath = "/dns-query"
+
+	mimeDNSMessage = "application/dns-message"
+	mimeDNSJSON    = "application/dns-json"
+
+	// RFC 8484 messages are bounded by the DNS wire format itself
+	maxMessageSize = dns.MaxMsgSize
+)
+
+var dohLogger *Logger.ModuleLogger
+
+func init() {
+	var err error
+	dohLogger, err = Logger.GetLogger("DoH")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for DoH module:", err)
+	}
+}
+
+type DoHServer struct {
+	Addr      string
+	Path      string
+	CertPath  string
+	KeyPath   string
+	TLSConfig *tls.Config
+	View      string
+	Server    *http.Server
+}
+
+// NewDoHServer serves RFC 8484 and the JSON API on path over HTTP/2 with the given certificate.
+// Every request, oblivious ones included, belongs to view.
+func NewDoHServer(addr, path, certPath, keyPath, view string) (*DoHServer, error) {
+	tlsConfig, err := Certs.LoadServerTLSConfig(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	if err := Access.ConfigureClientAuth(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	d := &DoHServer{
+		Addr:      addr,
+		Path:      path,
+		CertPath:  certPath,
+		KeyPath:   keyPath,
+		TLSConfig: tlsConfig,
+		View:      view,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, d.handleQuery)
+
+	// Oblivious DoH: the target shares the query path, the proxy gets its own
+	odoh := Loader.Current().ODoH
+	if odoh.Target.Enabled {
+		if err := ODoH.InitTarget(); err != nil {
+			return nil, err
+		}
+		mux.HandleFunc(ODoH.ConfigsPath, ODoH.ServeConfigs)
+	}
+	if odoh.Proxy.Enabled {
+		if err := ODoH.InitProxy(); err != nil {
+			return nil, err
+		}
+		mux.HandleFunc(odoh.Proxy.Path, ODoH.ServeProxy)
+	}
+
+	d.Server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		BaseContext:       func(net.Listener) context.Context { return Access.WithView(context.Background(), view) },
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	return d, nil
+}
+
+// Start binds the DoH listener and serves in the background, returning once the socket is bound
+func (d *DoHServer) Start() error {
+	dohLogger.Info(fmt.Sprintf("🚀 Starting DNS-over-HTTPS server on %s%s", d.Addr, d.Path))
+	listener, err := ProxyProtocol.Listen(d.Addr)
+	if err != nil {
+		return err
+	}
+	Discovery.Register(Discovery.ProtocolDoH, d.Addr, d.Path, d.View)
+
+	go func() {
+		if err := d.Server.ServeTLS(listener, "", ""); err != http.ErrServerClosed {
+			dohLogger.Error(fmt.Sprintf("❌ DoH server on %s stopped: %v", d.Addr, err))
+		}
+	}()
+	return nil
+}
+
+// Stop the server gracefully, connections still busy when ctx is done are closed
+func (d *DoHServer) Stop(ctx context.Context) error {
+	dohLogger.Info("Stopping DoH server...")
+	if err := d.Server.Shutdown(ctx); err != nil {
+		_ = d.Server.Close()
+		return err
+	}
+	return nil
+}
+
+func (d *DoHServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		d.handleJSON(w, r)
+		return
+	}
+
+	var (
+		query []byte
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		query, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+	case http.MethodPost:
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if ct == ODoH.MimeType && Loader.Current().ODoH.Target.Enabled {
+			ODoH.ServeTarget(w, r)
+			return
+		}
+		if ct != mimeDNSMessage {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		query, err = io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+		if err == nil && len(query) > maxMessageSize {
+			http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "malformed dns parameter", http.StatusBadRequest)
+		return
+	}
+
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		http.Error(w, "malformed DNS message", http.StatusBadRequest)
+		return
+	}
+
+	resp := Pipeline.Serve(Access.FromHTTP(r), req)
+	if Padding.Requested(req) {
+		Padding.Pad(resp, Padding.ResponseBlock)
+	}
+	packed, err := resp.Pack()
+	if err != nil {
+		dohLogger.Error("Failed to pack DNS response: " + err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeDNSMessage)
+	w.Header().Set("Cache-Control", cacheControl(resp))
+	_, _ = w.Write(packed)
+}
+
+// wantsJSON selects the JSON API by Accept header, ct parameter or the presence of name=
+func wantsJSON(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	q := r.URL.Query()
+	if q.Get("ct") == mimeDNSJSON || q.Has("name") {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, _ := mime.ParseMediaType(strings.TrimSpace(accept)); mt == mimeDNSJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl bounds HTTP caching by the smallest TTL in the response (RFC 8484 section 5.1)
+func cacheControl(m *dns.Msg) string {
+	if m.Rcode == dns.RcodeServerFailure {
+		return "no-store"
+	}
+
+	var minTTL uint32
+	found := false
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if !found || rr.Header().Ttl < minTTL {
+				minTTL = rr.Header().Ttl
+				found = true
+			}
+		}
+	}
+	return fmt.Sprintf("max-age=%d", minTTL)
+}
@@ -0,0 +1,110 @@
+package DoH
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
+)
+
+// JSON API in the format popularised by Google and Cloudflare:
+//
+//	GET /dns-query?name=example.com&type=AAAA[&do=1][&cd=1]
+//	Accept: application/dns-json
+type jsonQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type jsonRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type jsonResponse struct {
+	Status    int            `json:"Status"`
+	TC        bool           `json:"TC"`
+	RD        bool           `json:"RD"`
+	RA        bool           `json:"RA"`
+	AD        bool           `json:"AD"`
+	CD        bool           `json:"CD"`
+	Question  []jsonQuestion `json:"Question"`
+	Answer    []jsonRR       `json:"Answer,omitempty"`
+	Authority []jsonRR       `json:"Authority,omitempty"`
+}
+
+func (d *DoHServer) handleJSON(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	name := params.Get("name")
+	if _, ok := dns.IsDomainName(name); name == "" || !ok {
+		http.Error(w, "invalid name parameter", http.StatusBadRequest)
+		return
+	}
+	qtype, ok := parseType(params.Get("type"))
+	if !ok {
+		http.Error(w, "invalid type parameter", http.StatusBadRequest)
+		return
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	req.CheckingDisabled = isTrue(params.Get("cd"))
+	if isTrue(params.Get("do")) {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+
+	resp := Pipeline.Serve(Access.FromHTTP(r), req)
+
+	out := jsonResponse{
+		Status:    resp.Rcode,
+		TC:        resp.Truncated,
+		RD:        resp.RecursionDesired,
+		RA:        resp.RecursionAvailable,
+		AD:        resp.AuthenticatedData,
+		CD:        resp.CheckingDisabled,
+		Question:  []jsonQuestion{{Name: req.Question[0].Name, Type: qtype}},
+		Answer:    toJSONRRs(resp.Answer),
+		Authority: toJSONRRs(resp.Ns),
+	}
+
+	w.Header().Set("Content-Type", mimeDNSJSON)
+	w.Header().Set("Cache-Control", cacheControl(resp))
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// parseType accepts a mnemonic such as AAAA or a decimal type number, defaulting to A
+func parseType(s string) (uint16, bool) {
+	if s == "" {
+		return dns.TypeA, true
+	}
+	if t, ok := dns.StringToType[strings.ToUpper(s)]; ok {
+		return t, true
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	return uint16(n), err == nil && n > 0
+}
+
+func isTrue(s string) bool {
+	return s == "1" || strings.EqualFold(s, "true")
+}
+
+func toJSONRRs(rrs []dns.RR) []jsonRR {
+	out := make([]jsonRR, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		out = append(out, jsonRR{
+			Name: hdr.Name,
+			Type: hdr.Rrtype,
+			TTL:  hdr.Ttl,
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	return out
+}
@@ -0,0 +1,49 @@
+package DoQ
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// Exchange sends one query to a DoQ server over a fresh connection and waits for the answer.
+// It is enough to check a listener over loopback or to talk to a DoQ upstream.
+func Exchange(ctx context.Context, addr string, tlsConfig *tls.Config, m *dns.Msg) (*dns.Msg, error) {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{ALPN}
+
+	conn, err := quic.DialAddr(ctx, addr, tlsConfig, &quic.Config{MaxIdleTimeout: idleTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.CloseWithError(ErrNoError, "")
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	// The ID is always 0 on the wire and restored for the caller
+	id := m.Id
+	query := m.Copy()
+	query.Id = 0
+	if err := writeMsg(stream, query); err != nil {
+		return nil, fmt.Errorf("failed to send query: %w", err)
+	}
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := readMsg(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Id = id
+	return resp, nil
+}
@@ -0,0 +1,281 @@
+package DoQ
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Certs"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Discovery"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Systemd"
+	"github.com/quic-go/quic-go"
+)
+
+// ALPN token and error codes from RFC 9250 section 4.3
+const (
+	ALPN = "doq"
+
+	ErrNoError          = 0x0
+	ErrInternal         = 0x1
+	ErrProtocol         = 0x2
+	ErrRequestCancelled = 0x3
+	ErrExcessiveLoad    = 0x4
+	ErrUnspecified      = 0x5
+)
+
+const (
+	idleTimeout   = 30 * time.Second
+	streamTimeout = 10 * time.Second
+	maxStreams    = 100
+)
+
+var doqLogger *Logger.ModuleLogger
+
+func init() {
+	var err error
+	doqLogger, err = Logger.GetLogger("DoQ")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for DoQ module:", err)
+	}
+}
+
+type DoQServer struct {
+	Addr      string
+	CertPath  string
+	KeyPath   string
+	TLSConfig *tls.Config
+	View      string
+	Listener  *quic.EarlyListener
+	conn      *net.UDPConn
+
+	// Streams in flight, tracked so Stop can let them finish
+	mu       sync.Mutex
+	draining bool
+	streams  sync.WaitGroup
+}
+
+// NewDoQServer prepares an RFC 9250 listener with the same certificate as DoT, its clients belong to view
+func NewDoQServer(addr, certPath, keyPath, view string) (*DoQServer, error) {
+	tlsConfig, err := Certs.LoadServerTLSConfig(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = tls.VersionTLS13
+	tlsConfig.NextProtos = []string{ALPN}
+
+	return &DoQServer{
+		Addr:      addr,
+		CertPath:  certPath,
+		KeyPath:   keyPath,
+		TLSConfig: tlsConfig,
+		View:      view,
+	}, nil
+}
+
+// Start binds the UDP socket, or takes the one systemd passed, and accepts connections in the
+// background until Stop is called
+func (d *DoQServer) Start() error {
+	conn, err := Systemd.ListenUDP(d.Addr)
+	if err != nil {
+		return err
+	}
+	listener, err := quic.ListenEarly(conn, d.TLSConfig, &quic.Config{
+		MaxIdleTimeout:        idleTimeout,
+		MaxIncomingStreams:    maxStreams,
+		MaxIncomingUniStreams: -1,
+		Allow0RTT:             true,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	d.conn = conn
+	d.Listener = listener
+	doqLogger.Info(fmt.Sprintf("🚀 Starting DNS-over-QUIC server on %s", listener.Addr()))
+	Discovery.Register(Discovery.ProtocolDoQ, d.Addr, "", d.View)
+
+	go d.accept()
+	return nil
+}
+
+func (d *DoQServer) accept() {
+	for {
+		conn, err := d.Listener.Accept(context.Background())
+		if err != nil {
+			if !errors.Is(err, quic.ErrServerClosed) {
+				doqLogger.Error(fmt.Sprintf("❌ DoQ server on %s stopped: %v", d.Addr, err))
+			}
+			return
+		}
+		go d.serveConn(conn)
+	}
+}
+
+// Stop refuses new streams, waits for the ones in flight until ctx is done, then closes the
+// listener and every connection accepted from it
+func (d *DoQServer) Stop(ctx context.Context) error {
+	doqLogger.Info("Stopping DoQ server...")
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		d.streams.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if d.Listener != nil {
+		if closeErr := d.Listener.Close(); err == nil {
+			err = closeErr
+		}
+		// The socket was handed to quic, which leaves closing it to us
+		d.conn.Close()
+	}
+	return err
+}
+
+func (d *DoQServer) serveConn(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			_ = conn.CloseWithError(ErrNoError, "shutting down")
+			return
+		}
+		d.streams.Add(1)
+		d.mu.Unlock()
+
+		go func() {
+			defer d.streams.Done()
+			d.serveStream(conn, stream)
+		}()
+	}
+}
+
+// serveStream answers the single query a client sends on each bidirectional stream
+func (d *DoQServer) serveStream(conn *quic.Conn, stream *quic.Stream) {
+	_ = stream.SetDeadline(time.Now().Add(streamTimeout))
+
+	// 0-RTT data can be replayed (RFC 9250 section 4.5). Once the connection accepted it, a
+	// stream cannot be told apart from one opened after the handshake, so every stream of such
+	// a connection counts as early and only idempotent queries are answered on it.
+	early := conn.ConnectionState().Used0RTT
+
+	req, err := readMsg(stream)
+	if err != nil {
+		doqLogger.Warn(fmt.Sprintf("Malformed DoQ query from %s: %v", conn.RemoteAddr(), err))
+		_ = conn.CloseWithError(ErrProtocol, "malformed query")
+		return
+	}
+	if err := validateQuery(req); err != nil {
+		doqLogger.Warn(fmt.Sprintf("Protocol error from %s: %v", conn.RemoteAddr(), err))
+		_ = conn.CloseWithError(ErrProtocol, err.Error())
+		return
+	}
+
+	var resp *dns.Msg
+	if early && !idempotent(req) {
+		resp = tooEarly(req)
+	} else {
+		state := conn.ConnectionState().TLS
+		resp = Pipeline.Serve(Access.FromTLS(&state, conn.RemoteAddr()).InView(d.View), req)
+	}
+	resp.Id = 0
+	if Padding.Requested(req) {
+		Padding.Pad(resp, Padding.ResponseBlock)
+	}
+
+	if err := writeMsg(stream, resp); err != nil {
+		doqLogger.Warn(fmt.Sprintf("Failed to send DoQ response to %s: %v", conn.RemoteAddr(), err))
+		stream.CancelWrite(ErrInternal)
+		return
+	}
+	_ = stream.Close()
+}
+
+// validateQuery applies the RFC 9250 section 4.2.1 and 5.5.2 rules
+func validateQuery(req *dns.Msg) error {
+	if req.Id != 0 {
+		return fmt.Errorf("message ID must be 0, got %d", req.Id)
+	}
+	if opt := req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if o.Option() == dns.EDNS0TCPKEEPALIVE {
+				return errors.New("edns-tcp-keepalive is not allowed over QUIC")
+			}
+		}
+	}
+	return nil
+}
+
+// idempotent reports whether answering req twice is harmless, which holds for plain queries
+// but not for opcodes such as UPDATE or NOTIFY that change state
+func idempotent(req *dns.Msg) bool {
+	return req.Opcode == dns.OpcodeQuery
+}
+
+// tooEarly refuses a non-idempotent request that arrived in 0-RTT data (RFC 9250 section 4.5)
+func tooEarly(req *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(req, dns.RcodeRefused)
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeTooEarly})
+	return m
+}
+
+// readMsg reads one 2-byte length prefixed message up to the stream FIN
+func readMsg(r io.Reader) (*dns.Msg, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, 2+dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	length := int(binary.BigEndian.Uint16(buf))
+	if len(buf)-2 != length {
+		return nil, fmt.Errorf("length prefix %d does not match %d bytes received", length, len(buf)-2)
+	}
+
+	m := new(dns.Msg)
+	if err := m.Unpack(buf[2:]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeMsg writes one 2-byte length prefixed message
+func writeMsg(w io.Writer, m *dns.Msg) error {
+	packed, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 2, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	_, err = w.Write(append(buf, packed...))
+	return err
+}
@@ -0,0 +1,233 @@
+package DoQ
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// startServer runs a DoQ listener on a loopback port with a throwaway certificate
+func startServer(t *testing.T) (*DoQServer, *x509.CertPool) {
+	t.Helper()
+	certPath, keyPath, pool := writeCertificate(t)
+
+	srv, err := NewDoQServer("127.0.0.1:0", certPath, keyPath, "")
+	if err != nil {
+		t.Fatalf("NewDoQServer: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+	})
+	return srv, pool
+}
+
+// writeCertificate creates a self-signed certificate for 127.0.0.1 in a temporary directory
+func writeCertificate(t *testing.T) (certPath, keyPath string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "doq.test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certPath, keyPath, pool
+}
+
+func clientTLS(pool *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		RootCAs:            pool,
+		NextProtos:         []string{ALPN},
+		ClientSessionCache: tls.NewLRUClientSessionCache(4),
+	}
+}
+
+// exchange sends m on a new stream of conn and reads the answer
+func exchange(t *testing.T, conn *quic.Conn, m *dns.Msg) (*dns.Msg, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_ = stream.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := writeMsg(stream, m); err != nil {
+		return nil, err
+	}
+	// The FIN tells the server the query is complete
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+	return readMsg(stream)
+}
+
+// A query outside resolver.arpa would need the network, these are answered locally
+func localQuery(opcode int) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("_dns.resolver.arpa.", dns.TypeSVCB)
+	m.Opcode = opcode
+	m.Id = 0
+	return m
+}
+
+func TestQueryOverLoopback(t *testing.T) {
+	srv, pool := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, srv.Listener.Addr().String(), clientTLS(pool), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseWithError(ErrNoError, "")
+
+	// Several streams on one connection, each carrying a single query
+	for i := 0; i < 3; i++ {
+		resp, err := exchange(t, conn, localQuery(dns.OpcodeQuery))
+		if err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+		if resp.Id != 0 {
+			t.Errorf("query %d: response ID %d, want 0", i, resp.Id)
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			t.Errorf("query %d: rcode %s, want NOERROR", i, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
+
+func TestNonZeroIDClosesConnection(t *testing.T) {
+	srv, pool := startServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, srv.Listener.Addr().String(), clientTLS(pool), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	query := localQuery(dns.OpcodeQuery)
+	query.Id = 1234
+	if _, err := exchange(t, conn, query); err == nil {
+		t.Fatal("query with a non-zero ID was answered")
+	}
+
+	var appErr *quic.ApplicationError
+	select {
+	case <-conn.Context().Done():
+	case <-ctx.Done():
+		t.Fatal("connection was not closed")
+	}
+	if err := context.Cause(conn.Context()); !errors.As(err, &appErr) || appErr.ErrorCode != ErrProtocol {
+		t.Errorf("connection closed with %v, want DOQ_PROTOCOL_ERROR", err)
+	}
+}
+
+func TestEarlyDataOnlyAnswersIdempotentQueries(t *testing.T) {
+	srv, pool := startServer(t)
+	addr := srv.Listener.Addr().String()
+	tlsConf := clientTLS(pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A first connection leaves a session ticket in the client cache
+	first, err := quic.DialAddr(ctx, addr, tlsConf, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if _, err := exchange(t, first, localQuery(dns.OpcodeQuery)); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	_ = first.CloseWithError(ErrNoError, "")
+
+	early, err := quic.DialAddrEarly(ctx, addr, tlsConf, nil)
+	if err != nil {
+		t.Fatalf("dial with 0-RTT: %v", err)
+	}
+	defer early.CloseWithError(ErrNoError, "")
+
+	update, err := exchange(t, early, localQuery(dns.OpcodeUpdate))
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if !early.ConnectionState().Used0RTT {
+		t.Skip("the server did not accept 0-RTT data")
+	}
+	if update.Rcode != dns.RcodeRefused {
+		t.Errorf("update in 0-RTT got %s, want REFUSED", dns.RcodeToString[update.Rcode])
+	}
+	if ede := extendedError(update); ede == nil || ede.InfoCode != dns.ExtendedErrorCodeTooEarly {
+		t.Errorf("update in 0-RTT got extended error %v, want Too Early", ede)
+	}
+
+	// The handshake is complete by now, but the connection still accepted early data
+	<-early.HandshakeComplete()
+	if update, err = exchange(t, early, localQuery(dns.OpcodeUpdate)); err != nil || update.Rcode != dns.RcodeRefused {
+		t.Errorf("update after the handshake of a 0-RTT connection got %v, %v, want REFUSED", update, err)
+	}
+	query, err := exchange(t, early, localQuery(dns.OpcodeQuery))
+	if err != nil || query.Rcode != dns.RcodeSuccess {
+		t.Errorf("query on a 0-RTT connection got %v, %v, want NOERROR", query, err)
+	}
+}
+
+func extendedError(m *dns.Msg) *dns.EDNS0_EDE {
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ede, ok := o.(*dns.EDNS0_EDE); ok {
+				return ede
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package DoT
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+)
+
+// How long a client may take to read a response before the connection is dropped
+const writeTimeout = 10 * time.Second
+
+// dotConn is one client connection. Queries are read in order and answered concurrently,
+// up to one per slot, and responses go out as they are ready (RFC 7766 section 6.2.1.1).
+type dotConn struct {
+	server *DoTServer
+	tls    *tls.Conn
+	ip     string
+	state  tls.ConnectionState
+
+	handshakeTimeout time.Duration
+	idleTimeout      time.Duration
+	slots            chan struct{}
+
+	pending sync.WaitGroup
+	writeMu sync.Mutex
+}
+
+func (c *dotConn) serve() {
+	defer c.server.release(c)
+	defer c.tls.Close()
+
+	_ = c.tls.SetDeadline(time.Now().Add(c.handshakeTimeout))
+	if err := c.tls.Handshake(); err != nil {
+		if !c.server.stopping() {
+			reason := "handshake_failed"
+			if isTimeout(err) {
+				reason = "handshake_timeout"
+			}
+			rejected.With(reason).Inc()
+		}
+		return
+	}
+	c.state = c.tls.ConnectionState()
+
+	for c.armIdle() {
+		var prefix [2]byte
+		if n, err := io.ReadFull(c.tls, prefix[:]); err != nil {
+			// Idle means no query outstanding (RFC 7766 section 6.2.3), a slow answer keeps it open
+			if n == 0 && isTimeout(err) && len(c.slots) > 0 && !c.server.stopping() {
+				continue
+			}
+			break
+		}
+		body := make([]byte, binary.BigEndian.Uint16(prefix[:]))
+		if _, err := io.ReadFull(c.tls, body); err != nil {
+			break
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(body); err != nil {
+			dotLogger.Warn(fmt.Sprintf("Malformed DoT query from %s: %v", c.tls.RemoteAddr(), err))
+			break
+		}
+
+		// A full connection stops reading, so TCP pushes back on the client
+		c.slots <- struct{}{}
+		c.pending.Add(1)
+		go c.answer(req)
+	}
+	c.pending.Wait()
+}
+
+// armIdle sets the idle deadline for the next query, false once the server is draining.
+// Checked under the lock Stop holds, so a draining deadline is never pushed back.
+func (c *dotConn) armIdle() bool {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if c.server.draining {
+		return false
+	}
+	_ = c.tls.SetReadDeadline(time.Now().Add(c.idleTimeout))
+	return true
+}
+
+// wake interrupts a read waiting for the next query, called by Stop under the server lock
+func (c *dotConn) wake() {
+	_ = c.tls.SetReadDeadline(time.Now())
+}
+
+func (c *dotConn) answer(req *dns.Msg) {
+	defer func() {
+		<-c.slots
+		c.pending.Done()
+	}()
+	c.server.handler.ServeDNS(&responseWriter{conn: c, keepalive: wantsKeepalive(req)}, req)
+}
+
+// write sends one length-prefixed message, responses finishing together take turns
+func (c *dotConn) write(msg []byte) (int, error) {
+	if len(msg) > dns.MaxMsgSize {
+		return 0, fmt.Errorf("response of %d bytes does not fit a DoT frame", len(msg))
+	}
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.tls.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.tls.Write(frame); err != nil {
+		// A timed out TLS write leaves the connection unusable
+		c.tls.Close()
+		return 0, err
+	}
+	return len(msg), nil
+}
+
+// keepalive is the edns-tcp-keepalive timeout to advertise: the idle timeout, or 0 while
+// draining to ask the client to close once its answers arrive (RFC 7828 section 3.3.2)
+func (c *dotConn) keepalive() dns.EDNS0 {
+	if c.server.stopping() {
+		// EDNS0_TCP_KEEPALIVE packs a zero timeout as an empty option, which means "none given"
+		return &dns.EDNS0_LOCAL{Code: dns.EDNS0TCPKEEPALIVE, Data: []byte{0, 0}}
+	}
+	return &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: uint16(c.idleTimeout / (100 * time.Millisecond))}
+}
+
+// wantsKeepalive reports whether the query carries edns-tcp-keepalive, a server only
+// answers with the option when asked (RFC 7828 section 3.2.2)
+func wantsKeepalive(m *dns.Msg) bool {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0TCPKEEPALIVE {
+			return true
+		}
+	}
+	return false
+}
+
+// responseWriter lets the pipeline answer one query on a dotConn
+type responseWriter struct {
+	conn      *dotConn
+	keepalive bool
+}
+
+func (w *responseWriter) LocalAddr() net.Addr  { return w.conn.tls.LocalAddr() }
+func (w *responseWriter) RemoteAddr() net.Addr { return w.conn.tls.RemoteAddr() }
+
+// ConnectionState hands the pipeline the client certificate and marks the transport encrypted
+func (w *responseWriter) ConnectionState() *tls.ConnectionState { return &w.conn.state }
+
+func (w *responseWriter) WriteMsg(m *dns.Msg) error {
+	if w.keepalive {
+		if m.IsEdns0() == nil {
+			m.SetEdns0(dns.DefaultMsgSize, false)
+		}
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, w.conn.keepalive())
+		// Padding is sized last, so it has to account for the new option
+		if Padding.Requested(m) {
+			Padding.Pad(m, Padding.ResponseBlock)
+		}
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(packed)
+	return err
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) { return w.conn.write(b) }
+func (w *responseWriter) Close() error                { return w.conn.tls.Close() }
+func (w *responseWriter) TsigStatus() error           { return nil }
+func (w *responseWriter) TsigTimersOnly(bool)         {}
+func (w *responseWriter) Hijack()                     {}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
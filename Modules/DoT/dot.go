@@ -1,38 +1,68 @@
 package DoT
 
 import (
+	"context"
 	"crypto/tls"
-	"log"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
-	"github.com/official-biswadeb941/HopZero-DNS/Modules/Resolver"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Certs"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Discovery"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/ProxyProtocol"
 )
 
+var (
+	dotLogger *Logger.ModuleLogger
+
+	openConns = Metrics.NewGauge("hopzero_dot_connections", "Open client connections on DoT listeners")
+	rejected  = Metrics.NewCounterVec("hopzero_dot_rejected_connections_total", "DoT connections closed before a query was read, by reason", "reason")
+)
+
+func init() {
+	var err error
+	dotLogger, err = Logger.GetLogger("DoT")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for DoT module:", err)
+	}
+}
+
+// DoTServer accepts TLS connections itself rather than through dns.Server, so it can limit
+// connections and answer the queries a client pipelines on one connection concurrently
 type DoTServer struct {
 	Addr      string
 	CertPath  string
 	KeyPath   string
 	TLSConfig *tls.Config
-	Server    *dns.Server
+	View      string
+	Listener  net.Listener
+
+	handler dns.Handler
+
+	// Connections in flight, tracked so Stop can let them finish
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+	conns    map[*dotConn]struct{}
+	clients  map[string]int // open connections per client IP
 }
 
-// Initialize a new DoT server
-func NewDoTServer(addr, certPath, keyPath string) (*DoTServer, error) {
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+// Initialize a new DoT server whose clients belong to view
+func NewDoTServer(addr, certPath, keyPath, view string) (*DoTServer, error) {
+	tlsConfig, err := Certs.LoadServerTLSConfig(certPath, keyPath)
 	if err != nil {
 		return nil, err
 	}
-
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
-	}
-
-	srv := &dns.Server{
-		Addr:      addr,
-		Net:       "tcp-tls",
-		TLSConfig: tlsConfig,
-		Handler:   dns.HandlerFunc(basicDNSHandler), // Will replace this later
+	if err := Access.ConfigureClientAuth(tlsConfig); err != nil {
+		return nil, err
 	}
 
 	return &DoTServer{
@@ -40,39 +70,137 @@ func NewDoTServer(addr, certPath, keyPath string) (*DoTServer, error) {
 		CertPath:  certPath,
 		KeyPath:   keyPath,
 		TLSConfig: tlsConfig,
-		Server:    srv,
+		View:      view,
+		handler:   Pipeline.Handler(view),
+		conns:     make(map[*dotConn]struct{}),
+		clients:   make(map[string]int),
 	}, nil
 }
 
-// Start the DoT server
+// Start binds the DoT listener and accepts in the background, returning once the socket is
+// bound. TLS runs on top of PROXY header handling so the conveyed client is seen.
 func (d *DoTServer) Start() error {
-	log.Printf("[+] Starting DNS-over-TLS server on %s\n", d.Addr)
-	return d.Server.ListenAndServe()
-}
+	l, err := ProxyProtocol.Listen(d.Addr)
+	if err != nil {
+		return err
+	}
+	d.Listener = l
+	dotLogger.Info(fmt.Sprintf("🚀 Starting DNS-over-TLS server on %s", d.Addr))
+	Discovery.Register(Discovery.ProtocolDoT, d.Addr, "", d.View)
 
-// Stop the server gracefully
-func (d *DoTServer) Stop() error {
-	log.Println("[-] Stopping DoT server...")
-	return d.Server.Shutdown()
+	go d.accept()
+	return nil
 }
 
-// Temporary DNS handler — replace this with actual resolution logic
-func basicDNSHandler(w dns.ResponseWriter, r *dns.Msg) {
-	m := new(dns.Msg)
-	m.SetReply(r)
+// Stop refuses new connections, lets queries in flight finish until ctx is done, then
+// closes whatever is still open
+func (d *DoTServer) Stop(ctx context.Context) error {
+	dotLogger.Info("🛑 Stopping DoT server...")
+	d.mu.Lock()
+	d.draining = true
+	if d.Listener != nil {
+		d.Listener.Close()
+	}
+	// Idle clients are waiting for their next query, wake them up
+	for c := range d.conns {
+		c.wake()
+	}
+	d.mu.Unlock()
 
-	// Process each question (e.g., for A, AAAA records)
-	for _, q := range r.Question {
-		// Call the actual resolver function for real resolution
-		answers, err := Resolver.RecursiveResolve(q.Name, q.Qtype)
+	drained := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	d.mu.Lock()
+	for c := range d.conns {
+		c.tls.Close()
+	}
+	d.mu.Unlock()
+	return ctx.Err()
+}
+
+func (d *DoTServer) accept() {
+	for {
+		raw, err := d.Listener.Accept()
 		if err != nil {
-			log.Printf("Failed to resolve %s: %v", q.Name, err)
-			m.Rcode = dns.RcodeServerFailure
-			_ = w.WriteMsg(m)
-			return
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			dotLogger.Warn("⚠️ DoT accept failed: " + err.Error())
+			time.Sleep(100 * time.Millisecond)
+			continue
 		}
-		m.Answer = append(m.Answer, answers...)
+		c, reason := d.admit(raw)
+		if c == nil {
+			rejected.With(reason).Inc()
+			raw.Close()
+			continue
+		}
+		go c.serve()
+	}
+}
+
+// admit counts a new connection against the limits in the dot section, or returns why it is
+// refused. Limits are read per connection, so a reload applies to the next client.
+func (d *DoTServer) admit(raw net.Conn) (*dotConn, string) {
+	limits := Loader.Current().DoT
+	ip := clientIP(raw.RemoteAddr())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.draining:
+		return nil, "draining"
+	case limits.MaxConnections > 0 && len(d.conns) >= limits.MaxConnections:
+		return nil, "max_connections"
+	case limits.MaxConnectionsPerClient > 0 && d.clients[ip] >= limits.MaxConnectionsPerClient:
+		return nil, "max_connections_per_client"
 	}
 
-	_ = w.WriteMsg(m)
+	c := &dotConn{
+		server:           d,
+		tls:              tls.Server(raw, d.TLSConfig),
+		ip:               ip,
+		handshakeTimeout: time.Duration(limits.HandshakeTimeout) * time.Second,
+		idleTimeout:      time.Duration(limits.IdleTimeout) * time.Second,
+		slots:            make(chan struct{}, limits.MaxInflight),
+	}
+	d.conns[c] = struct{}{}
+	d.clients[ip]++
+	d.inflight.Add(1)
+	openConns.Inc()
+	return c, ""
+}
+
+func (d *DoTServer) stopping() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// release undoes admit once a connection is closed
+func (d *DoTServer) release(c *dotConn) {
+	d.mu.Lock()
+	delete(d.conns, c)
+	if d.clients[c.ip]--; d.clients[c.ip] <= 0 {
+		delete(d.clients, c.ip)
+	}
+	d.mu.Unlock()
+	openConns.Dec()
+	d.inflight.Done()
+}
+
+// clientIP keys the per-client limit, the address without its port
+func clientIP(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
 }
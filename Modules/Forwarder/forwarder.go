@@ -0,0 +1,271 @@
+package Forwarder
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+)
+
+// Load-balancing strategies
+const (
+	StrategyFailover   = "failover"    // first healthy upstream in configured order
+	StrategyRoundRobin = "round_robin" // rotate through healthy upstreams
+	StrategyFastest    = "fastest"     // healthy upstream with the lowest measured latency
+)
+
+const (
+	// Consecutive failures before an upstream is treated as down
+	unhealthyAfter = 3
+	// How long a group replaced by a reload keeps serving queries that already picked it
+	retireAfter = 30 * time.Second
+)
+
+var (
+	forwarderLogger *Logger.ModuleLogger
+
+	// defaultGroup is the group used by resolver mode "forward", set up by InitForwarder
+	defaultGroup atomic.Pointer[Group]
+
+	upstreamUp      = Metrics.NewGaugeVec("hopzero_upstream_up", "Whether a forwarder upstream is healthy", "upstream")
+	upstreamLatency = Metrics.NewGaugeVec("hopzero_upstream_latency_ms", "Smoothed response time of a forwarder upstream", "upstream")
+	upstreamQueries = Metrics.NewCounterVec("hopzero_upstream_queries_total", "Queries sent to a forwarder upstream", "upstream")
+	upstreamFailed  = Metrics.NewCounterVec("hopzero_upstream_failures_total", "Queries a forwarder upstream failed to answer", "upstream")
+)
+
+func init() {
+	var err error
+	forwarderLogger, err = Logger.GetLogger("Forwarder")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Forwarder module:", err)
+	}
+}
+
+// member tracks the health and latency of one upstream inside a group
+type member struct {
+	upstream Upstream
+	failures atomic.Int32
+	latency  atomic.Int64 // smoothed round trip in nanoseconds, 0 until measured
+}
+
+func (m *member) healthy() bool {
+	return m.failures.Load() < unhealthyAfter
+}
+
+// observe records the outcome of one exchange
+func (m *member) observe(rtt time.Duration, err error) {
+	name := m.upstream.Name()
+	if err != nil {
+		upstreamFailed.With(name).Inc()
+		if m.failures.Add(1) == unhealthyAfter {
+			upstreamUp.With(name).Set(0)
+			forwarderLogger.Warn(fmt.Sprintf("⚠️ Upstream %s marked down: %v", name, err))
+		}
+		return
+	}
+
+	if m.failures.Swap(0) >= unhealthyAfter {
+		forwarderLogger.Info(fmt.Sprintf("✅ Upstream %s is healthy again", name))
+	}
+	upstreamUp.With(name).Set(1)
+
+	// Exponentially weighted moving average, new samples count for a quarter
+	prev := m.latency.Load()
+	next := int64(rtt)
+	if prev != 0 {
+		next = (prev*3 + int64(rtt)) / 4
+	}
+	m.latency.Store(next)
+	upstreamLatency.With(name).Set(next / int64(time.Millisecond))
+}
+
+// Group forwards queries to a set of upstreams using one strategy
+type Group struct {
+	name      string
+	strategy  string
+	members   []*member
+	next      atomic.Uint64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewGroup builds the upstreams of a group and starts its health checks
+func NewGroup(name string, conf Loader.ForwarderGroup) (*Group, error) {
+	g := &Group{name: name, strategy: conf.Strategy, done: make(chan struct{})}
+	if g.strategy == "" {
+		g.strategy = StrategyFailover
+	}
+
+	for _, upstreamConf := range conf.Upstreams {
+		upstream, err := NewUpstream(upstreamConf)
+		if err != nil {
+			return nil, err
+		}
+		g.members = append(g.members, &member{upstream: upstream})
+		upstreamUp.With(upstream.Name()).Set(1)
+	}
+	if len(g.members) == 0 {
+		return nil, fmt.Errorf("forwarder group %s has no upstreams", name)
+	}
+
+	if conf.HealthCheckInterval > 0 {
+		go g.healthCheck(time.Duration(conf.HealthCheckInterval) * time.Second)
+	}
+	forwarderLogger.Info(fmt.Sprintf("🔀 Forwarder group %s ready with %d upstream(s), strategy %s", name, len(g.members), g.strategy))
+	return g, nil
+}
+
+// InitForwarder sets up the default group from the forwarder section of the config when
+// resolver.mode or a view forwards, and retires the previous one when called again after
+// a reload. A purely recursive config opens no upstream connections.
+func InitForwarder() error {
+	conf := Loader.Current()
+	var group *Group
+	if conf.UsesResolverMode("forward", "auto") && len(conf.Forwarder.Upstreams) > 0 {
+		var err error
+		if group, err = NewGroup("default", conf.Forwarder); err != nil {
+			return err
+		}
+	}
+	if old := defaultGroup.Swap(group); old != nil {
+		old.Retire()
+	}
+	return nil
+}
+
+// Default returns the group used by resolver modes forward and auto, nil when no mode forwards
+func Default() *Group {
+	return defaultGroup.Load()
+}
+
+// Close stops the health checks and drops pooled upstream connections
+func (g *Group) Close() {
+	g.closeOnce.Do(func() {
+		close(g.done)
+		for _, mem := range g.members {
+			if closer, ok := mem.upstream.(interface{ Close() }); ok {
+				closer.Close()
+			}
+		}
+	})
+}
+
+// Retire closes a group replaced by a config reload once queries already holding it are done
+func (g *Group) Retire() {
+	time.AfterFunc(retireAfter, g.Close)
+}
+
+// Exchange sends the query to upstreams in strategy order until one answers usefully.
+// SERVFAIL and REFUSED move on to the next upstream; if all fail the last response is returned.
+func (g *Group) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	var lastResp *dns.Msg
+	var lastErr error
+
+	for _, mem := range g.order() {
+		name := mem.upstream.Name()
+		upstreamQueries.With(name).Inc()
+
+		start := time.Now()
+		resp, err := mem.upstream.Exchange(m)
+		if err == nil && (resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused) {
+			err = fmt.Errorf("upstream answered %s", dns.RcodeToString[resp.Rcode])
+			lastResp = resp
+		}
+		mem.observe(time.Since(start), err)
+
+		if err == nil {
+			return resp, nil
+		}
+		forwarderLogger.Warn(fmt.Sprintf("Upstream %s failed for %s: %v", name, m.Question[0].Name, err))
+		lastErr = err
+	}
+
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, fmt.Errorf("all upstreams of %s failed, last error: %w", g.name, lastErr)
+}
+
+// Healthy reports whether at least one upstream is currently considered up
+func (g *Group) Healthy() bool {
+	for _, mem := range g.members {
+		if mem.healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+// Latency returns the best smoothed latency among healthy upstreams, 0 if nothing is measured yet
+func (g *Group) Latency() time.Duration {
+	var best time.Duration
+	for _, mem := range g.members {
+		l := time.Duration(mem.latency.Load())
+		if mem.healthy() && l > 0 && (best == 0 || l < best) {
+			best = l
+		}
+	}
+	return best
+}
+
+// order lists the members to try: healthy ones by strategy, then the rest as a last resort
+func (g *Group) order() []*member {
+	var healthy, down []*member
+	for _, mem := range g.members {
+		if mem.healthy() {
+			healthy = append(healthy, mem)
+		} else {
+			down = append(down, mem)
+		}
+	}
+
+	switch g.strategy {
+	case StrategyRoundRobin:
+		if n := len(healthy); n > 1 {
+			start := int(g.next.Add(1) % uint64(n))
+			healthy = append(healthy[start:], healthy[:start]...)
+		}
+	case StrategyFastest:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			li, lj := healthy[i].latency.Load(), healthy[j].latency.Load()
+			// Unmeasured upstreams go first so they get a sample
+			if li == 0 || lj == 0 {
+				return li == 0 && lj != 0
+			}
+			return li < lj
+		})
+	}
+	return append(healthy, down...)
+}
+
+// healthCheck probes every upstream with a root NS query so down upstreams can recover
+// and latency stays measured even without traffic
+func (g *Group) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-g.done:
+			return
+		}
+		for _, mem := range g.members {
+			probe := new(dns.Msg)
+			probe.SetQuestion(".", dns.TypeNS)
+
+			start := time.Now()
+			resp, err := mem.upstream.Exchange(probe)
+			if err == nil && resp.Rcode != dns.RcodeSuccess {
+				err = fmt.Errorf("health probe answered %s", dns.RcodeToString[resp.Rcode])
+			}
+			mem.observe(time.Since(start), err)
+		}
+	}
+}
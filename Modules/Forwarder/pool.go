@@ -0,0 +1,375 @@
+package Forwarder
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+)
+
+// PoolOptions tune the persistent upstream DoT connections
+type PoolOptions struct {
+	Connections  int           // connections kept open to the upstream
+	MaxInflight  int           // pipelined queries allowed per connection
+	IdleTimeout  time.Duration // close a connection after this long without traffic
+	QueryTimeout time.Duration // give up on a single query after this long
+	DialTimeout  time.Duration // TCP connect plus TLS handshake
+}
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+var (
+	errPoolBackoff = errors.New("upstream unreachable, waiting before reconnecting")
+	errConnClosed  = errors.New("upstream connection closed")
+
+	upstreamConns    = Metrics.NewGauge("hopzero_dot_upstream_connections", "Open pipelined connections to DoT upstreams")
+	upstreamDials    = Metrics.NewCounter("hopzero_dot_upstream_dials_total", "TLS connections opened to DoT upstreams")
+	upstreamResumed  = Metrics.NewCounter("hopzero_dot_upstream_resumed_total", "DoT upstream handshakes that resumed a TLS session")
+	upstreamFailures = Metrics.NewCounter("hopzero_dot_upstream_dial_failures_total", "Failed attempts to connect to DoT upstreams")
+)
+
+// DoTPool keeps a few TLS connections to one upstream open and pipelines
+// queries over them (RFC 7766), matching responses by message ID
+type DoTPool struct {
+	addr      string
+	tlsConfig *tls.Config
+	opts      PoolOptions
+
+	mu      sync.Mutex
+	conns   []*pipeConn
+	dialing int
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// NewDoTPool creates a pool; connections are opened lazily by the first queries
+func NewDoTPool(addr string, tlsConfig *tls.Config, opts PoolOptions) *DoTPool {
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(opts.Connections * 2)
+	}
+	return &DoTPool{addr: addr, tlsConfig: tlsConfig, opts: opts}
+}
+
+// Exchange sends a query over a pooled connection and waits for its response
+func (p *DoTPool) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	conn, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	return conn.exchange(m, p.opts.QueryTimeout)
+}
+
+// Close shuts every pooled connection
+func (p *DoTPool) Close() {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+
+	for _, c := range conns {
+		c.close(errConnClosed)
+	}
+}
+
+// get returns the least loaded live connection, dialing a new one while below the pool size
+func (p *DoTPool) get() (*pipeConn, error) {
+	p.mu.Lock()
+
+	var best *pipeConn
+	live := p.conns[:0]
+	for _, c := range p.conns {
+		if c.isClosed() {
+			continue
+		}
+		live = append(live, c)
+		if n := c.inflight(); n < p.opts.MaxInflight && (best == nil || n < best.inflight()) {
+			best = c
+		}
+	}
+	p.conns = live
+
+	// Reuse an idle connection, or any with room once no more may be opened
+	canDial := len(p.conns)+p.dialing < p.opts.Connections && time.Now().After(p.retryAt)
+	if best != nil && (best.inflight() == 0 || !canDial) {
+		p.mu.Unlock()
+		return best, nil
+	}
+	if !canDial {
+		p.mu.Unlock()
+		if time.Now().Before(p.retryAt) {
+			return nil, errPoolBackoff
+		}
+		return nil, fmt.Errorf("all %d upstream connections are busy", len(live))
+	}
+	p.dialing++
+	p.mu.Unlock()
+
+	conn, err := p.dial()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	if err != nil {
+		p.backoff = min(max(p.backoff*2, minBackoff), maxBackoff)
+		p.retryAt = time.Now().Add(p.backoff)
+		upstreamFailures.Inc()
+		forwarderLogger.Warn(fmt.Sprintf("⚠️ Failed to connect to DoT upstream %s, retrying in %s: %v", p.addr, p.backoff, err))
+		if best != nil {
+			return best, nil
+		}
+		return nil, err
+	}
+	p.backoff = 0
+	p.retryAt = time.Time{}
+	p.conns = append(p.conns, conn)
+	return conn, nil
+}
+
+func (p *DoTPool) dial() (*pipeConn, error) {
+	dialer := &net.Dialer{Timeout: p.opts.DialTimeout}
+	tlsConn, err := tls.DialWithDialer(dialer, "tcp", p.addr, p.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreamDials.Inc()
+	if tlsConn.ConnectionState().DidResume {
+		upstreamResumed.Inc()
+	}
+	forwarderLogger.Info(fmt.Sprintf("🔗 Opened pipelined DoT connection to %s (resumed=%v)", p.addr, tlsConn.ConnectionState().DidResume))
+
+	raw := &countingConn{Conn: tlsConn}
+	c := &pipeConn{
+		conn:         &dns.Conn{Conn: raw},
+		raw:          raw,
+		pending:      make(map[uint16]chan *dns.Msg),
+		idleTimeout:  p.opts.IdleTimeout,
+		queryTimeout: p.opts.QueryTimeout,
+	}
+	upstreamConns.Inc()
+	go c.readLoop()
+	return c, nil
+}
+
+// pipeConn is one upstream connection carrying many queries at once. Only readLoop sets
+// the read deadline, each query waits on its own timer.
+type pipeConn struct {
+	conn    *dns.Conn
+	raw     *countingConn
+	writeMu sync.Mutex
+
+	mu           sync.Mutex
+	pending      map[uint16]chan *dns.Msg
+	closed       bool
+	draining     bool
+	err          error
+	idleTimeout  time.Duration
+	queryTimeout time.Duration
+}
+
+func (c *pipeConn) inflight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+func (c *pipeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed || c.draining
+}
+
+func (c *pipeConn) exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	query := m.Copy()
+	hadEDNS := query.IsEdns0() != nil
+	requestKeepalive(query)
+	Padding.Pad(query, Padding.QueryBlock)
+
+	ch := make(chan *dns.Msg, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errConnClosed
+	}
+	// Client IDs can collide on a shared connection, so each query gets a connection-unique one
+	id := uint16(rand.UintN(1 << 16))
+	for c.pending[id] != nil {
+		id++
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	query.Id = id
+
+	c.writeMu.Lock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	err := c.conn.WriteMsg(query)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.close(err)
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, c.closeErr()
+		}
+		resp.Id = m.Id
+		stripHopByHop(resp, hadEDNS)
+		return resp, nil
+	case <-timer.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("upstream query timed out after %s", timeout)
+	}
+}
+
+// readLoop delivers responses in whatever order the upstream sends them
+func (c *pipeConn) readLoop() {
+	for {
+		c.mu.Lock()
+		wait := c.idleTimeout
+		if len(c.pending) > 0 {
+			wait = max(wait, c.queryTimeout)
+		}
+		c.mu.Unlock()
+		_ = c.conn.SetReadDeadline(time.Now().Add(wait))
+
+		c.raw.read = 0
+		resp, err := c.conn.ReadMsg()
+		if err != nil {
+			// The deadline was set for an idle connection, but queries were sent since. Their
+			// timers bound the wait, so keep reading unless a response was cut off halfway.
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && c.raw.read == 0 && c.inflight() > 0 {
+				continue
+			}
+			c.close(err)
+			return
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[resp.Id]
+		delete(c.pending, resp.Id)
+		c.applyKeepalive(resp)
+		drained := c.draining && len(c.pending) == 0
+		c.mu.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+		if drained {
+			c.close(errConnClosed)
+			return
+		}
+	}
+}
+
+// countingConn counts the bytes read since readLoop last reset it. Only readLoop reads.
+type countingConn struct {
+	net.Conn
+	read int
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read += n
+	return n, err
+}
+
+// applyKeepalive honours the idle timeout the upstream advertises (RFC 7828 section 3.3.2)
+func (c *pipeConn) applyKeepalive(resp *dns.Msg) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return
+	}
+	for _, o := range opt.Option {
+		if ka, ok := o.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+			if ka.Timeout == 0 {
+				// The server wants the connection closed once outstanding queries are answered
+				c.draining = true
+				return
+			}
+			c.idleTimeout = min(c.idleTimeout, time.Duration(ka.Timeout)*100*time.Millisecond)
+		}
+	}
+}
+
+func (c *pipeConn) close(err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.err = err
+	pending := c.pending
+	c.pending = make(map[uint16]chan *dns.Msg)
+	c.mu.Unlock()
+
+	_ = c.conn.Close()
+	upstreamConns.Dec()
+	for _, ch := range pending {
+		close(ch)
+	}
+}
+
+func (c *pipeConn) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return fmt.Errorf("%w: %v", errConnClosed, c.err)
+	}
+	return errConnClosed
+}
+
+// requestKeepalive adds an empty edns-tcp-keepalive option to the query
+func requestKeepalive(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt = m.IsEdns0()
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0TCPKEEPALIVE {
+			return
+		}
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+}
+
+// stripHopByHop removes the keepalive and padding options, and the OPT record we added, from a response
+func stripHopByHop(m *dns.Msg, keepOPT bool) {
+	for i, rr := range m.Extra {
+		opt, ok := rr.(*dns.OPT)
+		if !ok {
+			continue
+		}
+		if !keepOPT {
+			m.Extra = append(m.Extra[:i], m.Extra[i+1:]...)
+			return
+		}
+		options := opt.Option[:0]
+		for _, o := range opt.Option {
+			if o.Option() != dns.EDNS0TCPKEEPALIVE && o.Option() != dns.EDNS0PADDING {
+				options = append(options, o)
+			}
+		}
+		opt.Option = options
+		return
+	}
+}
@@ -0,0 +1,215 @@
+package Forwarder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+)
+
+const defaultUpstreamTimeout = 3 * time.Second
+
+// Upstream is one server queries can be forwarded to
+type Upstream interface {
+	Name() string
+	Exchange(m *dns.Msg) (*dns.Msg, error)
+}
+
+// NewUpstream builds a Do53, DoT or DoH upstream from its config entry
+func NewUpstream(conf Loader.Upstream) (Upstream, error) {
+	timeout := defaultUpstreamTimeout
+	if conf.Timeout > 0 {
+		timeout = time.Duration(conf.Timeout) * time.Second
+	}
+	name := conf.Name
+	if name == "" {
+		name = conf.Protocol + "://" + conf.Addr + conf.URL
+	}
+
+	switch conf.Protocol {
+	case "do53":
+		return &do53Upstream{name: name, addr: conf.Addr, timeout: timeout}, nil
+	case "dot":
+		tlsConfig, err := clientTLSConfig(conf)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s: %w", name, err)
+		}
+		return &dotUpstream{name: name, pool: NewDoTPool(conf.Addr, tlsConfig, PoolOptions{
+			Connections:  2,
+			MaxInflight:  100,
+			IdleTimeout:  30 * time.Second,
+			QueryTimeout: timeout,
+			DialTimeout:  timeout,
+		})}, nil
+	case "doh":
+		tlsConfig, err := clientTLSConfig(conf)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s: %w", name, err)
+		}
+		return &dohUpstream{name: name, url: conf.URL, client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig:   tlsConfig,
+				ForceAttemptHTTP2: true,
+				IdleConnTimeout:   90 * time.Second,
+			},
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown upstream protocol %q", conf.Protocol)
+}
+
+// clientTLSConfig verifies the upstream by hostname, by SPKI pin, or both
+func clientTLSConfig(conf Loader.Upstream) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: conf.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if conf.CAFile != "" {
+		caCert, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", conf.CAFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if len(conf.SPKIPins) == 0 {
+		return tlsConfig, nil
+	}
+
+	pins := make(map[string]bool, len(conf.SPKIPins))
+	for _, pin := range conf.SPKIPins {
+		if raw, err := base64.StdEncoding.DecodeString(pin); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("spki pin %q is not a base64 SHA-256 digest", pin)
+		}
+		pins[pin] = true
+	}
+
+	// Without a hostname the pin is the only trust anchor (RFC 7858 out-of-band key-pinned profile)
+	if conf.ServerName == "" {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	pinned := func(cert *x509.Certificate) bool {
+		digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return pins[base64.StdEncoding.EncodeToString(digest[:])]
+	}
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		// Unverified, only the leaf proves key possession: any other certificate could be appended by anyone
+		if tlsConfig.InsecureSkipVerify {
+			if len(cs.PeerCertificates) > 0 && pinned(cs.PeerCertificates[0]) {
+				return nil
+			}
+			return fmt.Errorf("server certificate does not match the configured SPKI pins")
+		}
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				if pinned(cert) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no certificate in the verified chain matches the configured SPKI pins")
+	}
+	return tlsConfig, nil
+}
+
+// do53Upstream sends queries over UDP and retries truncated answers over TCP
+type do53Upstream struct {
+	name    string
+	addr    string
+	timeout time.Duration
+}
+
+func (u *do53Upstream) Name() string { return u.name }
+
+func (u *do53Upstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: "udp", Timeout: u.timeout}
+	resp, _, err := client.Exchange(m, u.addr)
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.Exchange(m, u.addr)
+	}
+	return resp, err
+}
+
+// dotUpstream sends queries over a persistent, pipelined TLS connection pool
+type dotUpstream struct {
+	name string
+	pool *DoTPool
+}
+
+func (u *dotUpstream) Name() string { return u.name }
+
+func (u *dotUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	return u.pool.Exchange(m)
+}
+
+func (u *dotUpstream) Close() { u.pool.Close() }
+
+// dohUpstream POSTs RFC 8484 messages over HTTP/2
+type dohUpstream struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (u *dohUpstream) Name() string { return u.name }
+
+func (u *dohUpstream) Close() { u.client.CloseIdleConnections() }
+
+func (u *dohUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	// ID 0 keeps the query cache friendly (RFC 8484 section 4.1)
+	query := m.Copy()
+	query.Id = 0
+	if query.IsEdns0() == nil {
+		query.SetEdns0(dns.DefaultMsgSize, false)
+	}
+	Padding.Pad(query, Padding.QueryBlock)
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	httpResp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH upstream answered HTTP %d", httpResp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, fmt.Errorf("malformed DoH response: %w", err)
+	}
+	resp.Id = m.Id
+	Padding.Strip(resp)
+	return resp, nil
+}
@@ -3,20 +3,121 @@ package Loader
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Upstream describes one server queries can be forwarded to
+type Upstream struct {
+	Name       string   `yaml:"name"`
+	Protocol   string   `yaml:"protocol"`
+	Addr       string   `yaml:"addr"`
+	URL        string   `yaml:"url"`
+	ServerName string   `yaml:"server_name"`
+	SPKIPins   []string `yaml:"spki_pins"`
+	CAFile     string   `yaml:"ca_file"`
+	Timeout    int      `yaml:"timeout"`
+}
+
+// ForwarderGroup is a set of upstreams sharing a load-balancing strategy
+type ForwarderGroup struct {
+	Strategy            string     `yaml:"strategy"`
+	HealthCheckInterval int        `yaml:"health_check_interval"`
+	Upstreams           []Upstream `yaml:"upstreams"`
+}
+
+// Zone sends queries under a domain suffix to its own servers instead of the root
+type Zone struct {
+	Name      string         `yaml:"name"`
+	Type      string         `yaml:"type"`
+	DNSSEC    string         `yaml:"dnssec"`
+	Forwarder ForwarderGroup `yaml:"forwarder"`
+	Servers   []string       `yaml:"servers"`
+}
+
+// Listener is one endpoint HopZero serves on
+type Listener struct {
+	Protocol string `yaml:"protocol"`
+	Addr     string `yaml:"addr"`
+	Path     string `yaml:"path"`
+	TLS      string `yaml:"tls"`
+	View     string `yaml:"view"`
+	Sockets  int    `yaml:"sockets"`
+	Workers  int    `yaml:"workers"`
+}
+
+// TLSProfile is a certificate pair listeners refer to by name
+type TLSProfile struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// ACLRule allows or denies the client identities matching a glob
+type ACLRule struct {
+	Identity string `yaml:"identity"`
+	Action   string `yaml:"action"`
+}
+
+// View is the policy attached to listeners: their own ACL and resolver mode
+type View struct {
+	Name         string    `yaml:"name"`
+	ResolverMode string    `yaml:"resolver_mode"`
+	ACL          []ACLRule `yaml:"acl"`
+}
+
+// Discovery advertises the encrypted listeners under _dns.resolver.arpa (RFC 9462)
+type Discovery struct {
+	Enabled   bool     `yaml:"enabled"`
+	Target    string   `yaml:"target"`
+	IPv4Hints []string `yaml:"ipv4_hints"`
+	IPv6Hints []string `yaml:"ipv6_hints"`
+	TTL       int      `yaml:"ttl"`
+}
+
+// Transports each listener protocol binds, used to catch two listeners on one socket
+var listenerTransports = map[string][]string{
+	"udp":      {"udp"},
+	"tcp":      {"tcp"},
+	"dot":      {"tcp"},
+	"doh":      {"tcp"},
+	"doq":      {"udp"},
+	"dnscrypt": {"udp", "tcp"},
+}
+
 type Config struct {
 	Redis struct {
-		Addr           string `yaml:"addr"`
-		Password       string `yaml:"password"`
-		DB             int    `yaml:"db"`
+		Mode             string   `yaml:"mode"`
+		Addr             string   `yaml:"addr"`
+		Addrs            []string `yaml:"addrs"`
+		MasterName       string   `yaml:"master_name"`
+		Username         string   `yaml:"username"`
+		Password         string   `yaml:"password"`
+		SentinelUsername string   `yaml:"sentinel_username"`
+		SentinelPassword string   `yaml:"sentinel_password"`
+		DB               int      `yaml:"db"`
+		TLS              struct {
+			Enabled    bool   `yaml:"enabled"`
+			CAFile     string `yaml:"ca_file"`
+			CertFile   string `yaml:"cert_file"`
+			KeyFile    string `yaml:"key_file"`
+			ServerName string `yaml:"server_name"`
+		} `yaml:"tls"`
 		ConnectionPool struct {
 			MaxConnections int `yaml:"max_connections"`
 			Timeout        int `yaml:"timeout"`
 		} `yaml:"connection_pool"`
+		CircuitBreaker struct {
+			FailureThreshold int `yaml:"failure_threshold"`
+			ProbeInterval    int `yaml:"probe_interval"`
+		} `yaml:"circuit_breaker"`
 	} `yaml:"redis"`
 
 	MySQL struct {
@@ -32,58 +133,824 @@ type Config struct {
 			Timeout          int    `yaml:"timeout"`
 		} `yaml:"connection_pool"`
 	} `yaml:"mysql"`
+
+	Resolver struct {
+		Mode   string `yaml:"mode"`
+		Policy struct {
+			MaxFailureRate         float64 `yaml:"max_failure_rate"`
+			MinSamples             int     `yaml:"min_samples"`
+			LatencyFactor          float64 `yaml:"latency_factor"`
+			DNSSECPrefersRecursion bool    `yaml:"dnssec_prefers_recursion"`
+			Rules                  []struct {
+				Suffix string `yaml:"suffix"`
+				Route  string `yaml:"route"`
+			} `yaml:"rules"`
+		} `yaml:"policy"`
+	} `yaml:"resolver"`
+
+	Forwarder ForwarderGroup `yaml:"forwarder"`
+
+	Listeners []Listener `yaml:"listeners"`
+
+	Views []View `yaml:"views"`
+
+	Zones []Zone `yaml:"zones"`
+
+	Do53 struct {
+		Mode string `yaml:"mode"`
+	} `yaml:"do53"`
+
+	Proxy struct {
+		Pool struct {
+			Connections  int `yaml:"connections"`
+			MaxInflight  int `yaml:"max_inflight"`
+			IdleTimeout  int `yaml:"idle_timeout"`
+			QueryTimeout int `yaml:"query_timeout"`
+		} `yaml:"pool"`
+	} `yaml:"proxy"`
+
+	Cache struct {
+		KeyPrefix     string `yaml:"key_prefix"`
+		MemoryEntries int    `yaml:"memory_entries"`
+		Snapshot      struct {
+			Path           string `yaml:"path"`
+			LoadOnStartup  bool   `yaml:"load_on_startup"`
+			SaveOnShutdown bool   `yaml:"save_on_shutdown"`
+		} `yaml:"snapshot"`
+	} `yaml:"cache"`
+
+	TLS struct {
+		ReloadInterval  int                   `yaml:"reload_interval"`
+		Profiles        map[string]TLSProfile `yaml:"profiles"`
+		SNICertificates []TLSProfile          `yaml:"sni_certificates"`
+	} `yaml:"tls"`
+
+	MTLS struct {
+		Enabled        bool              `yaml:"enabled"`
+		Mode           string            `yaml:"mode"`
+		CAFile         string            `yaml:"ca_file"`
+		CRLFiles       []string          `yaml:"crl_files"`
+		OCSPFiles      []string          `yaml:"ocsp_files"`
+		IdentitySource string            `yaml:"identity_source"`
+		Identities     map[string]string `yaml:"identities"`
+	} `yaml:"mtls"`
+
+	Access struct {
+		ACL       []ACLRule `yaml:"acl"`
+		RateLimit struct {
+			QueriesPerSecond float64 `yaml:"queries_per_second"`
+			Burst            int     `yaml:"burst"`
+		} `yaml:"rate_limit"`
+	} `yaml:"access"`
+
+	ProxyProtocol struct {
+		TrustedCIDRs  []string `yaml:"trusted_cidrs"`
+		HeaderTimeout int      `yaml:"header_timeout"`
+	} `yaml:"proxy_protocol"`
+
+	Discovery Discovery `yaml:"discovery"`
+
+	ODoH struct {
+		Target struct {
+			Enabled bool   `yaml:"enabled"`
+			KeyFile string `yaml:"key_file"`
+		} `yaml:"target"`
+		Proxy struct {
+			Enabled        bool     `yaml:"enabled"`
+			Path           string   `yaml:"path"`
+			AllowedTargets []string `yaml:"allowed_targets"`
+			CAFile         string   `yaml:"ca_file"`
+			Timeout        int      `yaml:"timeout"`
+		} `yaml:"proxy"`
+	} `yaml:"odoh"`
+
+	DNSCrypt struct {
+		// Deprecated: replaced by a dnscrypt listener, only read from configs without listeners
+		Enabled          bool     `yaml:"enabled"`
+		Addr             string   `yaml:"addr"`
+		ProviderName     string   `yaml:"provider_name"`
+		ProviderKeyFile  string   `yaml:"provider_key_file"`
+		Constructions    []string `yaml:"constructions"`
+		CertTTL          int      `yaml:"cert_ttl"`
+		RotationInterval int      `yaml:"rotation_interval"`
+		Relay            struct {
+			Enabled        bool     `yaml:"enabled"`
+			AllowedTargets []string `yaml:"allowed_targets"`
+		} `yaml:"relay"`
+	} `yaml:"dnscrypt"`
+
+	DoT struct {
+		MaxConnections          int `yaml:"max_connections"`
+		MaxConnectionsPerClient int `yaml:"max_connections_per_client"`
+		HandshakeTimeout        int `yaml:"handshake_timeout"`
+		IdleTimeout             int `yaml:"idle_timeout"`
+		MaxInflight             int `yaml:"max_inflight"`
+	} `yaml:"dot"`
+
+	Metrics struct {
+		Enabled bool   `yaml:"enabled"`
+		Addr    string `yaml:"addr"`
+	} `yaml:"metrics"`
+
+	Shutdown struct {
+		Timeout int `yaml:"timeout"`
+	} `yaml:"shutdown"`
+
+	Sandbox struct {
+		User               string `yaml:"user"`
+		Group              string `yaml:"group"`
+		KeepNetBindService bool   `yaml:"keep_net_bind_service"`
+		Landlock           struct {
+			Enabled   bool     `yaml:"enabled"`
+			ReadOnly  []string `yaml:"read_only"`
+			ReadWrite []string `yaml:"read_write"`
+		} `yaml:"landlock"`
+		Seccomp struct {
+			Enabled bool   `yaml:"enabled"`
+			Mode    string `yaml:"mode"`
+		} `yaml:"seccomp"`
+	} `yaml:"sandbox"`
 }
 
-var AppConfig Config
+// current is the configuration in effect. A reload stores a new one and a stored config is
+// never modified, so whoever loads it reads a consistent snapshot.
+var current atomic.Pointer[Config]
+
+func init() {
+	current.Store(new(Config))
+}
+
+// Current returns the configuration in effect. Load it once per query or task and read
+// everything from that value, a reload may swap in a new one at any moment.
+func Current() *Config {
+	return current.Load()
+}
+
+// Use makes c the configuration in effect without reading a file, c must not be modified afterwards
+func Use(c *Config) {
+	current.Store(c)
+}
+
+// FindView returns the view called name, nil for "" or an unknown name
+func (c *Config) FindView(name string) *View {
+	if name == "" {
+		return nil
+	}
+	for i := range c.Views {
+		if c.Views[i].Name == name {
+			return &c.Views[i]
+		}
+	}
+	return nil
+}
+
+// UsesResolverMode reports whether resolver.mode or any view's resolver_mode is one of modes
+func (c *Config) UsesResolverMode(modes ...string) bool {
+	if slices.Contains(modes, c.Resolver.Mode) {
+		return true
+	}
+	for _, view := range c.Views {
+		if slices.Contains(modes, view.ResolverMode) {
+			return true
+		}
+	}
+	return false
+}
 
 // LoadConfig reads and loads the configuration from the given file
 func LoadConfig(path string) error {
+	next, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	current.Store(next)
+
+	log.Println("✅ Config loaded successfully")
+	return nil
+}
+
+// ReloadConfig re-reads the file and swaps in the new config only when it is valid.
+// It returns the sections that changed but are only read at startup, so need a restart.
+func ReloadConfig(path string) ([]string, error) {
+	next, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	old := current.Load()
+	var restart []string
+	for name, same := range map[string]bool{
+		"listeners":            reflect.DeepEqual(old.Listeners, next.Listeners),
+		"tls.profiles":         reflect.DeepEqual(old.TLS.Profiles, next.TLS.Profiles),
+		"tls.sni_certificates": reflect.DeepEqual(old.TLS.SNICertificates, next.TLS.SNICertificates),
+		"redis":                reflect.DeepEqual(old.Redis, next.Redis),
+		"do53":                 old.Do53 == next.Do53,
+		"proxy":                old.Proxy == next.Proxy,
+		"proxy_protocol":       reflect.DeepEqual(old.ProxyProtocol, next.ProxyProtocol),
+		"odoh":                 reflect.DeepEqual(old.ODoH, next.ODoH),
+		"dnscrypt":             reflect.DeepEqual(old.DNSCrypt, next.DNSCrypt),
+		"metrics":              old.Metrics == next.Metrics,
+		"mtls":                 reflect.DeepEqual(old.MTLS, next.MTLS),
+		"cache.memory_entries": old.Cache.MemoryEntries == next.Cache.MemoryEntries,
+		"sandbox":              reflect.DeepEqual(old.Sandbox, next.Sandbox),
+	} {
+		if !same {
+			restart = append(restart, name)
+		}
+	}
+	sort.Strings(restart)
+
+	current.Store(next)
+	log.Println("✅ Config reloaded successfully")
+	return restart, nil
+}
+
+// readConfig parses and validates the file without touching the config in effect
+func readConfig(path string) (*Config, error) {
 	// Read the configuration file
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("❌ Failed to read config file: %v", err)
+		return nil, fmt.Errorf("❌ Failed to read config file: %v", err)
 	}
 
-	// Unmarshal the YAML data into the AppConfig struct
-	if err := yaml.Unmarshal(data, &AppConfig); err != nil {
-		return fmt.Errorf("❌ Failed to parse config: %v", err)
+	// Unmarshal the YAML data into a fresh config
+	next := new(Config)
+	if err := yaml.Unmarshal(data, next); err != nil {
+		return nil, fmt.Errorf("❌ Failed to parse config: %v", err)
 	}
 
-	// Validate the loaded config
-	if err := validateConfig(); err != nil {
-		return fmt.Errorf("❌ Invalid config: %v", err)
+	// Fill in what older files leave out, then validate
+	next.applyDefaults()
+	if err := next.validate(); err != nil {
+		return nil, fmt.Errorf("❌ Invalid config: %v", err)
 	}
+	return next, nil
+}
 
-	log.Println("✅ Config loaded successfully")
-	return nil
+// applyDefaults fills the settings a config written before they existed leaves at zero
+func (c *Config) applyDefaults() {
+	if len(c.Listeners) == 0 {
+		c.applyLegacyListeners()
+	}
+	if c.Resolver.Mode == "" {
+		c.Resolver.Mode = "recursive"
+	}
+	if c.Do53.Mode == "" {
+		c.Do53.Mode = "recursive"
+	}
+	if c.Cache.KeyPrefix == "" {
+		c.Cache.KeyPrefix = "hopzero"
+	}
+	pool := &c.Proxy.Pool
+	pool.Connections = orDefault(pool.Connections, 2)
+	pool.MaxInflight = orDefault(pool.MaxInflight, 100)
+	pool.IdleTimeout = orDefault(pool.IdleTimeout, 30)
+	pool.QueryTimeout = orDefault(pool.QueryTimeout, 5)
+
+	dot := &c.DoT
+	dot.MaxConnections = orDefault(dot.MaxConnections, 10000)
+	dot.MaxConnectionsPerClient = orDefault(dot.MaxConnectionsPerClient, 32)
+	dot.HandshakeTimeout = orDefault(dot.HandshakeTimeout, 5)
+	dot.IdleTimeout = orDefault(dot.IdleTimeout, 10)
+	dot.MaxInflight = orDefault(dot.MaxInflight, 64)
+
+	policy := &c.Resolver.Policy
+	policy.MaxFailureRate = orDefault(policy.MaxFailureRate, 0.5)
+	policy.MinSamples = orDefault(policy.MinSamples, 10)
+	policy.LatencyFactor = orDefault(policy.LatencyFactor, 3)
+
+	// DNSCrypt decrypts every packet, so its UDP side is always bounded
+	for i := range c.Listeners {
+		if c.Listeners[i].Protocol == "dnscrypt" {
+			c.Listeners[i].Workers = orDefault(c.Listeners[i].Workers, 1024)
+		}
+	}
+
+	c.Shutdown.Timeout = orDefault(c.Shutdown.Timeout, 10)
+	if c.Sandbox.Seccomp.Mode == "" {
+		c.Sandbox.Seccomp.Mode = "enforce"
+	}
+}
+
+// Certificate pair every encrypted listener used before tls.profiles existed
+const (
+	legacyCertFile = "Modules/SSL/localhost.pem"
+	legacyKeyFile  = "Modules/SSL/localhost-key.pem"
+)
+
+// applyLegacyListeners serves what a config from before the listeners section got: DNS on
+// port 53, DoT, DoH and DoQ with the bundled certificate, and DNSCrypt when it was enabled
+func (c *Config) applyLegacyListeners() {
+	c.Listeners = []Listener{
+		{Protocol: "udp", Addr: ":53"},
+		{Protocol: "tcp", Addr: ":53"},
+		{Protocol: "dot", Addr: ":853", TLS: "default"},
+		{Protocol: "doh", Addr: ":443", Path: "/dns-query", TLS: "default"},
+		{Protocol: "doq", Addr: ":853", TLS: "default"},
+	}
+	if c.DNSCrypt.Enabled {
+		c.Listeners = append(c.Listeners, Listener{Protocol: "dnscrypt", Addr: c.DNSCrypt.Addr})
+	}
+	c.DNSCrypt.Enabled, c.DNSCrypt.Addr = false, ""
+	if _, ok := c.TLS.Profiles["default"]; !ok {
+		if c.TLS.Profiles == nil {
+			c.TLS.Profiles = make(map[string]TLSProfile)
+		}
+		c.TLS.Profiles["default"] = TLSProfile{CertFile: legacyCertFile, KeyFile: legacyKeyFile}
+	}
+	log.Println("⚠️ Config has no listeners section, serving the former built-in endpoints. Copy the listeners and tls.profiles sections from the sample Config.yaml to choose them.")
+}
+
+// orDefault returns value, or def when it was left out
+func orDefault[T int | float64](value, def T) T {
+	if value == 0 {
+		return def
+	}
+	return value
 }
 
-// validateConfig performs basic validation on the loaded config
-func validateConfig() error {
+// validate performs basic validation on a loaded config
+func (c *Config) validate() error {
 	// Check Redis configuration
-	if AppConfig.Redis.Addr == "" {
-		return fmt.Errorf("redis address is missing")
+	switch c.Redis.Mode {
+	case "", "standalone":
+		if c.Redis.Addr == "" {
+			return fmt.Errorf("redis address is missing")
+		}
+	case "sentinel":
+		if len(c.Redis.Addrs) == 0 || c.Redis.MasterName == "" {
+			return fmt.Errorf("redis sentinel mode needs addrs and master_name")
+		}
+	case "cluster":
+		if len(c.Redis.Addrs) == 0 {
+			return fmt.Errorf("redis cluster mode needs addrs")
+		}
+		if c.Redis.DB != 0 {
+			return fmt.Errorf("redis cluster mode only supports db 0")
+		}
+	default:
+		return fmt.Errorf("unknown redis mode %q", c.Redis.Mode)
+	}
+	if (c.Redis.TLS.CertFile == "") != (c.Redis.TLS.KeyFile == "") {
+		return fmt.Errorf("redis tls cert_file and key_file must be set together")
 	}
-	if AppConfig.Redis.ConnectionPool.MaxConnections <= 0 {
+	if c.Redis.ConnectionPool.MaxConnections <= 0 {
 		return fmt.Errorf("redis max_connections must be a positive number")
 	}
-	if AppConfig.Redis.ConnectionPool.Timeout <= 0 {
+	if c.Redis.ConnectionPool.Timeout <= 0 {
 		return fmt.Errorf("redis timeout must be a positive number")
 	}
+	// Left out or 0, the circuit breaker keeps its defaults
+	if c.Redis.CircuitBreaker.FailureThreshold < 0 || c.Redis.CircuitBreaker.ProbeInterval < 0 {
+		return fmt.Errorf("redis circuit_breaker failure_threshold and probe_interval must not be negative")
+	}
 
 	// Check MySQL configuration
-	if AppConfig.MySQL.User == "" || AppConfig.MySQL.Password == "" || AppConfig.MySQL.Host == "" || AppConfig.MySQL.Database == "" {
+	if c.MySQL.User == "" || c.MySQL.Password == "" || c.MySQL.Host == "" || c.MySQL.Database == "" {
 		return fmt.Errorf("MySQL configuration is incomplete")
 	}
-	if AppConfig.MySQL.Port <= 0 {
+	if c.MySQL.Port <= 0 {
 		return fmt.Errorf("MySQL port must be a positive number")
 	}
-	if AppConfig.MySQL.ConnectionPool.PoolSize <= 0 {
+	if c.MySQL.ConnectionPool.PoolSize <= 0 {
 		return fmt.Errorf("MySQL pool_size must be a positive number")
 	}
-	if AppConfig.MySQL.ConnectionPool.Timeout <= 0 {
+	if c.MySQL.ConnectionPool.Timeout <= 0 {
 		return fmt.Errorf("MySQL timeout must be a positive number")
 	}
 
+	// Check resolver configuration
+	switch c.Resolver.Mode {
+	case "recursive":
+	case "forward", "auto":
+		if len(c.Forwarder.Upstreams) == 0 {
+			return fmt.Errorf("resolver mode %s needs at least one forwarder upstream", c.Resolver.Mode)
+		}
+	default:
+		return fmt.Errorf("resolver mode must be recursive, forward or auto, got %q", c.Resolver.Mode)
+	}
+	// Thresholds only matter to mode auto, other configs may leave them out or at anything
+	policy := c.Resolver.Policy
+	if c.UsesResolverMode("auto") {
+		if policy.MaxFailureRate <= 0 || policy.MaxFailureRate > 1 {
+			return fmt.Errorf("resolver policy max_failure_rate must be above 0 and at most 1")
+		}
+		if policy.MinSamples < 1 {
+			return fmt.Errorf("resolver policy min_samples must be at least 1")
+		}
+		if policy.LatencyFactor < 1 {
+			return fmt.Errorf("resolver policy latency_factor must be at least 1")
+		}
+	}
+	for _, rule := range policy.Rules {
+		if rule.Suffix == "" {
+			return fmt.Errorf("resolver policy rule needs a suffix")
+		}
+		if rule.Route != "recurse" && rule.Route != "forward" {
+			return fmt.Errorf("resolver policy rule for %q route must be recurse or forward, got %q", rule.Suffix, rule.Route)
+		}
+	}
+	if err := validateForwarderGroup("forwarder", c.Forwarder); err != nil {
+		return err
+	}
+
+	// Check zone routing
+	seenZones := make(map[string]bool)
+	for i, zone := range c.Zones {
+		if zone.Name == "" {
+			return fmt.Errorf("zone %d needs a name", i+1)
+		}
+		name := strings.ToLower(strings.TrimSuffix(zone.Name, ".")) + "."
+		if seenZones[name] {
+			return fmt.Errorf("zone %q is configured twice", zone.Name)
+		}
+		seenZones[name] = true
+
+		switch zone.Type {
+		case "forward":
+			if len(zone.Forwarder.Upstreams) == 0 {
+				return fmt.Errorf("forward zone %q needs at least one forwarder upstream", zone.Name)
+			}
+			if err := validateForwarderGroup(fmt.Sprintf("zone %q forwarder", zone.Name), zone.Forwarder); err != nil {
+				return err
+			}
+		case "stub":
+			if len(zone.Servers) == 0 {
+				return fmt.Errorf("stub zone %q needs at least one server", zone.Name)
+			}
+			for _, server := range zone.Servers {
+				if _, _, err := net.SplitHostPort(server); err != nil {
+					return fmt.Errorf("stub zone %q server %q must be host:port", zone.Name, server)
+				}
+			}
+		default:
+			return fmt.Errorf("zone %q type must be forward or stub, got %q", zone.Name, zone.Type)
+		}
+
+		switch zone.DNSSEC {
+		case "", "validate", "insecure":
+		default:
+			return fmt.Errorf("zone %q dnssec must be validate or insecure, got %q", zone.Name, zone.DNSSEC)
+		}
+	}
+
+	// Check Do53 configuration
+	switch c.Do53.Mode {
+	case "recursive", "forward-dot":
+	default:
+		return fmt.Errorf("do53 mode must be recursive or forward-dot, got %q", c.Do53.Mode)
+	}
+
+	// Check proxy configuration
+	pool := c.Proxy.Pool
+	if pool.Connections <= 0 || pool.MaxInflight <= 0 || pool.IdleTimeout <= 0 || pool.QueryTimeout <= 0 {
+		return fmt.Errorf("proxy pool connections, max_inflight, idle_timeout and query_timeout must be positive numbers")
+	}
+	if pool.MaxInflight > 65535 {
+		return fmt.Errorf("proxy pool max_inflight cannot exceed 65535")
+	}
+
+	// Check cache configuration
+	if strings.ContainsAny(c.Cache.KeyPrefix, ":*?[]\\ ") {
+		return fmt.Errorf("cache key_prefix must not contain ':', spaces or glob characters")
+	}
+	if c.Cache.MemoryEntries < 0 {
+		return fmt.Errorf("cache memory_entries must not be negative")
+	}
+	if (c.Cache.Snapshot.LoadOnStartup || c.Cache.Snapshot.SaveOnShutdown) && c.Cache.Snapshot.Path == "" {
+		return fmt.Errorf("cache snapshot path is missing")
+	}
+
+	// Check TLS configuration
+	if c.TLS.ReloadInterval < 0 {
+		return fmt.Errorf("tls reload_interval must not be negative")
+	}
+	for i, pair := range c.TLS.SNICertificates {
+		if pair.CertFile == "" || pair.KeyFile == "" {
+			return fmt.Errorf("tls sni certificate %d needs cert_file and key_file", i+1)
+		}
+	}
+
+	// Check client authentication and access control
+	if c.MTLS.Enabled {
+		if c.MTLS.Mode != "optional" && c.MTLS.Mode != "required" {
+			return fmt.Errorf("mtls mode must be optional or required, got %q", c.MTLS.Mode)
+		}
+		if c.MTLS.CAFile == "" {
+			return fmt.Errorf("mtls ca_file is missing")
+		}
+		switch c.MTLS.IdentitySource {
+		case "cn", "san_dns", "san_email", "san_uri":
+		default:
+			return fmt.Errorf("mtls identity_source must be cn, san_dns, san_email or san_uri, got %q", c.MTLS.IdentitySource)
+		}
+	}
+	if err := validateACL("access acl", c.Access.ACL); err != nil {
+		return err
+	}
+	if c.Access.RateLimit.QueriesPerSecond < 0 || c.Access.RateLimit.Burst < 0 {
+		return fmt.Errorf("access rate_limit values must not be negative")
+	}
+
+	// Check PROXY protocol sources
+	for _, cidr := range c.ProxyProtocol.TrustedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("proxy_protocol trusted_cidrs entry %q is not a CIDR", cidr)
+		}
+	}
+	if len(c.ProxyProtocol.TrustedCIDRs) > 0 && c.ProxyProtocol.HeaderTimeout <= 0 {
+		return fmt.Errorf("proxy_protocol header_timeout must be positive")
+	}
+
+	// Check designated resolver discovery
+	if c.Discovery.Enabled {
+		// Clients only upgrade to a resolver they can reach and verify by name (RFC 9462 section 4.2)
+		target := strings.ToLower(strings.TrimSuffix(c.Discovery.Target, "."))
+		if target == "" {
+			return fmt.Errorf("discovery target is missing, it must be a name on the listeners' certificate")
+		}
+		if target == "localhost" || strings.HasSuffix(target, ".localhost") {
+			return fmt.Errorf("discovery target %q is loopback, clients could not reach it", c.Discovery.Target)
+		}
+		if net.ParseIP(target) != nil {
+			return fmt.Errorf("discovery target %q must be a hostname, not an address", c.Discovery.Target)
+		}
+		if c.Discovery.TTL < 0 {
+			return fmt.Errorf("discovery ttl must not be negative")
+		}
+		for _, hint := range c.Discovery.IPv4Hints {
+			if ip := net.ParseIP(hint); ip == nil || ip.To4() == nil {
+				return fmt.Errorf("discovery ipv4 hint %q is not an IPv4 address", hint)
+			}
+			if net.ParseIP(hint).IsLoopback() {
+				return fmt.Errorf("discovery ipv4 hint %q is loopback, clients could not reach it", hint)
+			}
+		}
+		for _, hint := range c.Discovery.IPv6Hints {
+			if ip := net.ParseIP(hint); ip == nil || ip.To4() != nil {
+				return fmt.Errorf("discovery ipv6 hint %q is not an IPv6 address", hint)
+			}
+			if net.ParseIP(hint).IsLoopback() {
+				return fmt.Errorf("discovery ipv6 hint %q is loopback, clients could not reach it", hint)
+			}
+		}
+	}
+
+	// Check the Oblivious DoH roles
+	if c.ODoH.Target.Enabled && c.ODoH.Target.KeyFile == "" {
+		return fmt.Errorf("odoh target key_file is missing")
+	}
+	if proxy := c.ODoH.Proxy; proxy.Enabled {
+		if !strings.HasPrefix(proxy.Path, "/") {
+			return fmt.Errorf("odoh proxy path must start with /, got %q", proxy.Path)
+		}
+		if len(proxy.AllowedTargets) == 0 {
+			return fmt.Errorf("odoh proxy needs allowed_targets, an open proxy is not supported")
+		}
+		// The proxy shares the DoH listeners' HTTP routes, a second handler on a path is refused
+		if c.ODoH.Target.Enabled && proxy.Path == "/.well-known/odohconfigs" {
+			return fmt.Errorf("odoh proxy path %s is where the target publishes its configs", proxy.Path)
+		}
+		for _, l := range c.Listeners {
+			if l.Protocol != "doh" {
+				continue
+			}
+			if path := l.Path; path == proxy.Path || (path == "" && proxy.Path == "/dns-query") {
+				return fmt.Errorf("odoh proxy path %s is the query path of doh listener %s", proxy.Path, l.Addr)
+			}
+		}
+		if proxy.Timeout <= 0 {
+			return fmt.Errorf("odoh proxy timeout must be positive")
+		}
+	}
+
+	// Check the DNSCrypt listener settings
+	if dc := c.DNSCrypt; c.hasListener("dnscrypt") {
+		if !strings.HasPrefix(strings.ToLower(dc.ProviderName), "2.dnscrypt-cert.") {
+			return fmt.Errorf("dnscrypt provider_name must start with 2.dnscrypt-cert., got %q", dc.ProviderName)
+		}
+		if dc.ProviderKeyFile == "" {
+			return fmt.Errorf("dnscrypt provider_key_file is missing")
+		}
+		if len(dc.Constructions) == 0 {
+			return fmt.Errorf("dnscrypt needs at least one construction")
+		}
+		for _, construction := range dc.Constructions {
+			if construction != "xsalsa20poly1305" && construction != "xchacha20poly1305" {
+				return fmt.Errorf("dnscrypt construction must be xsalsa20poly1305 or xchacha20poly1305, got %q", construction)
+			}
+		}
+		if dc.RotationInterval <= 0 || dc.CertTTL <= dc.RotationInterval {
+			return fmt.Errorf("dnscrypt rotation_interval must be positive and shorter than cert_ttl, so certificates overlap")
+		}
+		if dc.Relay.Enabled && len(dc.Relay.AllowedTargets) == 0 {
+			return fmt.Errorf("dnscrypt relay needs allowed_targets, an open relay is not supported")
+		}
+		for _, target := range dc.Relay.AllowedTargets {
+			host, _, err := net.SplitHostPort(target)
+			if err != nil || net.ParseIP(host) == nil {
+				return fmt.Errorf("dnscrypt relay target %q must be IP:port", target)
+			}
+		}
+	}
+
+	// Check views, then the listeners that attach them
+	if err := c.validateViews(); err != nil {
+		return err
+	}
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+
+	// Check DoT connection limits, the idle timeout is advertised in 100 ms units of a uint16
+	dot := c.DoT
+	if dot.MaxConnections < 0 || dot.MaxConnectionsPerClient < 0 {
+		return fmt.Errorf("dot max_connections and max_connections_per_client cannot be negative")
+	}
+	if dot.HandshakeTimeout < 0 || dot.MaxInflight < 0 {
+		return fmt.Errorf("dot handshake_timeout and max_inflight cannot be negative")
+	}
+	if dot.IdleTimeout <= 0 || dot.IdleTimeout > 6553 {
+		return fmt.Errorf("dot idle_timeout must be between 1 and 6553 seconds")
+	}
+
+	// Check metrics configuration
+	if c.Metrics.Enabled && c.Metrics.Addr == "" {
+		return fmt.Errorf("metrics address is missing")
+	}
+
+	// Check the shutdown drain deadline
+	if c.Shutdown.Timeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive")
+	}
+
+	// Check the privilege drop and sandbox settings
+	sb := c.Sandbox
+	if sb.Group != "" && sb.User == "" {
+		return fmt.Errorf("sandbox group needs a user to switch to")
+	}
+	if sb.Landlock.Enabled && len(sb.Landlock.ReadOnly)+len(sb.Landlock.ReadWrite) == 0 {
+		return fmt.Errorf("sandbox landlock needs read_only or read_write paths, otherwise no file could be opened")
+	}
+	if sb.Seccomp.Enabled && sb.Seccomp.Mode != "enforce" && sb.Seccomp.Mode != "log" {
+		return fmt.Errorf("sandbox seccomp mode must be enforce or log, got %q", sb.Seccomp.Mode)
+	}
+
+	return nil
+}
+
+// validateListeners checks every endpoint, its TLS profile and view, and that no two share a socket
+func (c *Config) validateListeners() error {
+	if len(c.Listeners) == 0 {
+		return fmt.Errorf("no listeners configured")
+	}
+	if c.DNSCrypt.Enabled || c.DNSCrypt.Addr != "" {
+		return fmt.Errorf("dnscrypt enabled and addr are replaced by a listener with protocol dnscrypt, move the address there")
+	}
+	for name, profile := range c.TLS.Profiles {
+		if profile.CertFile == "" || profile.KeyFile == "" {
+			return fmt.Errorf("tls profile %q needs cert_file and key_file", name)
+		}
+	}
+
+	bound := make(map[string]string)
+	for i, l := range c.Listeners {
+		label := fmt.Sprintf("listener %d (%s %s)", i+1, l.Protocol, l.Addr)
+		transports, ok := listenerTransports[l.Protocol]
+		if !ok {
+			return fmt.Errorf("listener %d protocol must be udp, tcp, dot, doh, doq or dnscrypt, got %q", i+1, l.Protocol)
+		}
+		host, port, err := net.SplitHostPort(l.Addr)
+		if err != nil || port == "" {
+			return fmt.Errorf("%s addr must be host:port, [ipv6]:port or :port", label)
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			return fmt.Errorf("%s must bind an IP address, got %q", label, host)
+		}
+
+		switch l.Protocol {
+		case "dot", "doh", "doq":
+			if _, ok := c.TLS.Profiles[l.TLS]; !ok {
+				return fmt.Errorf("%s needs a tls profile from tls.profiles, got %q", label, l.TLS)
+			}
+		default:
+			if l.TLS != "" {
+				return fmt.Errorf("%s does not use TLS, remove its tls profile", label)
+			}
+		}
+		if l.Path != "" && (l.Protocol != "doh" || !strings.HasPrefix(l.Path, "/")) {
+			return fmt.Errorf("%s path is only for doh and must start with /", label)
+		}
+		if l.Sockets < 0 || l.Workers < 0 {
+			return fmt.Errorf("%s sockets and workers cannot be negative", label)
+		}
+		if l.Sockets != 0 && l.Protocol != "udp" {
+			return fmt.Errorf("%s sockets are only for udp", label)
+		}
+		if l.Workers != 0 && l.Protocol != "udp" && l.Protocol != "dnscrypt" {
+			return fmt.Errorf("%s workers are only for udp and dnscrypt", label)
+		}
+		if l.View != "" && c.FindView(l.View) == nil {
+			return fmt.Errorf("%s view %q is not defined in views", label, l.View)
+		}
+
+		for _, transport := range transports {
+			socket := transport + " " + net.JoinHostPort(host, port)
+			if other, taken := bound[socket]; taken {
+				return fmt.Errorf("%s and %s both bind %s", label, other, socket)
+			}
+			bound[socket] = label
+		}
+	}
+	return nil
+}
+
+// validateViews checks view names, modes and ACLs
+func (c *Config) validateViews() error {
+	seen := make(map[string]bool)
+	for i, view := range c.Views {
+		if view.Name == "" || seen[view.Name] {
+			return fmt.Errorf("view %d needs a unique name", i+1)
+		}
+		seen[view.Name] = true
+		switch view.ResolverMode {
+		case "", "recursive":
+		case "forward", "auto":
+			if len(c.Forwarder.Upstreams) == 0 {
+				return fmt.Errorf("view %q resolver_mode %s needs forwarder upstreams", view.Name, view.ResolverMode)
+			}
+		default:
+			return fmt.Errorf("view %q resolver_mode must be recursive, forward or auto, got %q", view.Name, view.ResolverMode)
+		}
+		if err := validateACL(fmt.Sprintf("view %q acl", view.Name), view.ACL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateACL(name string, rules []ACLRule) error {
+	for i, rule := range rules {
+		if rule.Identity == "" {
+			return fmt.Errorf("%s rule %d needs an identity pattern", name, i+1)
+		}
+		if _, err := path.Match(rule.Identity, ""); err != nil {
+			return fmt.Errorf("%s rule %d identity %q is not a valid pattern", name, i+1, rule.Identity)
+		}
+		if rule.Action != "allow" && rule.Action != "deny" {
+			return fmt.Errorf("%s rule %d action must be allow or deny, got %q", name, i+1, rule.Action)
+		}
+	}
+	return nil
+}
+
+func (c *Config) hasListener(protocol string) bool {
+	for _, l := range c.Listeners {
+		if l.Protocol == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// validateForwarderGroup checks the strategy and every upstream of a group
+func validateForwarderGroup(name string, group ForwarderGroup) error {
+	switch group.Strategy {
+	case "", "failover", "round_robin", "fastest":
+	default:
+		return fmt.Errorf("%s strategy must be failover, round_robin or fastest, got %q", name, group.Strategy)
+	}
+	if group.HealthCheckInterval < 0 {
+		return fmt.Errorf("%s health_check_interval must not be negative", name)
+	}
+
+	for i, upstream := range group.Upstreams {
+		label := fmt.Sprintf("%s upstream %d", name, i+1)
+		if upstream.Name != "" {
+			label = fmt.Sprintf("%s upstream %q", name, upstream.Name)
+		}
+		switch upstream.Protocol {
+		case "do53", "dot":
+			if upstream.Addr == "" {
+				return fmt.Errorf("%s needs an addr", label)
+			}
+		case "doh":
+			if !strings.HasPrefix(upstream.URL, "https://") {
+				return fmt.Errorf("%s needs an https:// url", label)
+			}
+		default:
+			return fmt.Errorf("%s protocol must be do53, dot or doh, got %q", label, upstream.Protocol)
+		}
+		if upstream.Protocol == "do53" && (upstream.ServerName != "" || len(upstream.SPKIPins) > 0) {
+			return fmt.Errorf("%s is plain DNS and cannot use server_name or spki_pins", label)
+		}
+		// Without either, the certificate would only be checked against the IP address
+		if upstream.Protocol == "dot" && upstream.ServerName == "" && len(upstream.SPKIPins) == 0 {
+			return fmt.Errorf("%s needs a server_name or spki_pins to verify the server", label)
+		}
+		if upstream.Timeout < 0 {
+			return fmt.Errorf("%s timeout must not be negative", label)
+		}
+	}
 	return nil
 }
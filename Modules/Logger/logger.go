@@ -19,6 +19,7 @@ type ModuleLogger struct {
 	FileLogger *log.Logger
 	ModuleName string
 	lock       sync.Mutex
+	file       *os.File
 }
 
 // GetLogger returns a thread-safe logger for a module with automatic file naming
@@ -48,6 +49,7 @@ func GetLogger(moduleName string) (*ModuleLogger, error) {
 	modLogger := &ModuleLogger{
 		FileLogger: fileLogger,
 		ModuleName: moduleName,
+		file:       logFile,
 	}
 
 	loggers[moduleName] = modLogger
@@ -72,3 +74,15 @@ func (l *ModuleLogger) Error(msg string) {
 	defer l.lock.Unlock()
 	l.FileLogger.Printf("[🔥 %s][ERROR] %s", l.ModuleName, msg)
 }
+
+// Sync flushes every module's log file to disk, called last on shutdown
+func Sync() {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	for _, logger := range loggers {
+		logger.lock.Lock()
+		_ = logger.file.Sync()
+		logger.lock.Unlock()
+	}
+}
@@ -0,0 +1,227 @@
+package Metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+)
+
+var (
+	registry      = make(map[string]metric)
+	registryMu    sync.RWMutex
+	metricsLogger *Logger.ModuleLogger
+)
+
+func init() {
+	var err error
+	metricsLogger, err = Logger.GetLogger("Metrics")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Metrics module:", err)
+	}
+}
+
+type metric interface {
+	write(sb *strings.Builder)
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// NewCounter registers a counter under the given name, or returns the existing one
+func NewCounter(name, help string) *Counter {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m, ok := registry[name].(*Counter); ok {
+		return m
+	}
+	c := &Counter{name: name, help: help}
+	registry[name] = c
+	return c
+}
+
+// NewGauge registers a gauge under the given name, or returns the existing one
+func NewGauge(name, help string) *Gauge {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m, ok := registry[name].(*Gauge); ok {
+		return m
+	}
+	g := &Gauge{name: name, help: help}
+	registry[name] = g
+	return g
+}
+
+func (c *Counter) Inc()          { c.value.Add(1) }
+func (c *Counter) Add(n uint64)  { c.value.Add(n) }
+func (c *Counter) Value() uint64 { return c.value.Load() }
+func (g *Gauge) Set(v int64)     { g.value.Store(v) }
+func (g *Gauge) Inc()            { g.value.Add(1) }
+func (g *Gauge) Dec()            { g.value.Add(-1) }
+func (g *Gauge) Value() int64    { return g.value.Load() }
+
+func (c *Counter) write(sb *strings.Builder) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.Value())
+}
+
+func (g *Gauge) write(sb *strings.Builder) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.Value())
+}
+
+// GaugeVec is a family of gauges told apart by one label
+type GaugeVec struct {
+	name   string
+	help   string
+	label  string
+	mu     sync.Mutex
+	values map[string]*Gauge
+}
+
+// CounterVec is a family of counters told apart by one label
+type CounterVec struct {
+	name   string
+	help   string
+	label  string
+	mu     sync.Mutex
+	values map[string]*Counter
+}
+
+// NewGaugeVec registers a labelled gauge family under the given name, or returns the existing one
+func NewGaugeVec(name, help, label string) *GaugeVec {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m, ok := registry[name].(*GaugeVec); ok {
+		return m
+	}
+	v := &GaugeVec{name: name, help: help, label: label, values: make(map[string]*Gauge)}
+	registry[name] = v
+	return v
+}
+
+// NewCounterVec registers a labelled counter family under the given name, or returns the existing one
+func NewCounterVec(name, help, label string) *CounterVec {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if m, ok := registry[name].(*CounterVec); ok {
+		return m
+	}
+	v := &CounterVec{name: name, help: help, label: label, values: make(map[string]*Counter)}
+	registry[name] = v
+	return v
+}
+
+// With returns the gauge for one label value
+func (v *GaugeVec) With(value string) *Gauge {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	g, ok := v.values[value]
+	if !ok {
+		g = &Gauge{name: v.name}
+		v.values[value] = g
+	}
+	return g
+}
+
+// With returns the counter for one label value
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.values[value]
+	if !ok {
+		c = &Counter{name: v.name}
+		v.values[value] = c
+	}
+	return c
+}
+
+func (v *GaugeVec) write(sb *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s gauge\n", v.name, v.help, v.name)
+	for _, value := range sortedKeys(v.values) {
+		fmt.Fprintf(sb, "%s{%s=%q} %d\n", v.name, v.label, value, v.values[value].Value())
+	}
+}
+
+func (v *CounterVec) write(sb *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n", v.name, v.help, v.name)
+	for _, value := range sortedKeys(v.values) {
+		fmt.Fprintf(sb, "%s{%s=%q} %d\n", v.name, v.label, value, v.values[value].Value())
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Render returns all registered metrics in the Prometheus text exposition format
+func Render() string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		registry[name].write(&sb)
+	}
+	return sb.String()
+}
+
+// Handler serves the metrics page
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(Render()))
+	})
+}
+
+// Serve exposes /metrics on the given address in the background
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	// Bind before returning, privileges may be dropped right after startup
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		metricsLogger.Error("Metrics server failed to listen: " + err.Error())
+		return
+	}
+	go func() {
+		metricsLogger.Info(fmt.Sprintf("📈 Serving metrics on %s/metrics", addr))
+		if err := http.Serve(listener, mux); err != nil {
+			metricsLogger.Error("Metrics server stopped: " + err.Error())
+		}
+	}()
+}
@@ -0,0 +1,121 @@
+package ODoH
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+)
+
+// Client sends oblivious queries to a target, through a proxy when ProxyURL is set.
+// It is enough to check a target and proxy pair over loopback.
+type Client struct {
+	HTTP       *http.Client
+	TargetHost string // host[:port] of the target
+	TargetPath string // the target's DoH path, such as /dns-query
+	ProxyURL   string // full URL of the proxy endpoint, empty to query the target directly
+}
+
+// FetchConfig reads the target's configs and returns the first one this client supports
+func (c *Client) FetchConfig() (Config, error) {
+	resp, err := c.HTTP.Get((&url.URL{Scheme: "https", Host: c.TargetHost, Path: ConfigsPath}).String())
+	if err != nil {
+		return Config{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Config{}, fmt.Errorf("fetching configs: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return Config{}, err
+	}
+	configs, err := ParseConfigs(data)
+	if err != nil {
+		return Config{}, err
+	}
+	return configs[0], nil
+}
+
+// Exchange encrypts m for the target, sends it and decrypts the answer
+func (c *Client) Exchange(config Config, m *dns.Msg) (*dns.Msg, error) {
+	public, err := ecdh.X25519().NewPublicKey(config.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	wire, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	id := config.KeyID()
+	enc, sender, err := setupSender(public, []byte("odoh query"))
+	if err != nil {
+		return nil, err
+	}
+	padding := (Padding.QueryBlock - len(wire)%Padding.QueryBlock) % Padding.QueryBlock
+	queryPlain := plaintext(wire, padding)
+	ct, err := sender.seal(aad(messageQuery, id), queryPlain)
+	if err != nil {
+		return nil, err
+	}
+	body := message{kind: messageQuery, keyID: id, body: append(enc, ct...)}.marshal()
+
+	endpoint := (&url.URL{Scheme: "https", Host: c.TargetHost, Path: c.TargetPath}).String()
+	if c.ProxyURL != "" {
+		proxy, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy.RawQuery = url.Values{"targethost": {c.TargetHost}, "targetpath": {c.TargetPath}}.Encode()
+		endpoint = proxy.String()
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", MimeType)
+	req.Header.Set("Accept", MimeType)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oblivious query failed: %s", resp.Status)
+	}
+
+	msg, err := parseMessage(data)
+	if err != nil || msg.kind != messageResponse || len(msg.keyID) != responseNonceLen {
+		return nil, errors.New("malformed oblivious response")
+	}
+	secret, err := sender.export("odoh response", aeadKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	gcm, nonce, err := responseAEAD(secret, queryPlain, msg.keyID)
+	if err != nil {
+		return nil, err
+	}
+	respPlain, err := gcm.Open(nil, nonce, msg.body, aad(messageResponse, msg.keyID))
+	if err != nil {
+		return nil, errors.New("cannot decrypt oblivious response")
+	}
+	answer, err := parsePlaintext(respPlain)
+	if err != nil {
+		return nil, err
+	}
+	out := new(dns.Msg)
+	return out, out.Unpack(answer)
+}
@@ -0,0 +1,175 @@
+package ODoH
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// HPKE base mode (RFC 9180) for the one suite ODoH requires:
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and AES-128-GCM
+
+const (
+	hpkeModeBase = 0x00
+	// Nsecret of the KEM, Nh of the KDF
+	hpkeSecretLen = 32
+)
+
+var (
+	kemSuiteID  = binary.BigEndian.AppendUint16([]byte("KEM"), kemX25519)
+	hpkeSuiteID = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16([]byte("HPKE"), kemX25519), kdfSHA256), aeadAES128)
+)
+
+// hpkeContext is the encryption context both sides derive from the encapsulated key
+type hpkeContext struct {
+	aead           cipher.AEAD
+	baseNonce      []byte
+	seq            uint64
+	exporterSecret []byte
+}
+
+// setupSender encapsulates a fresh shared secret to public, enc goes to the recipient
+func setupSender(public *ecdh.PublicKey, info []byte) (enc []byte, ctx *hpkeContext, err error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return setupSenderWithKey(ephemeral, public, info)
+}
+
+// setupSenderWithKey is setupSender with a chosen ephemeral key, as in the RFC test vectors
+func setupSenderWithKey(ephemeral *ecdh.PrivateKey, public *ecdh.PublicKey, info []byte) ([]byte, *hpkeContext, error) {
+	dh, err := ephemeral.ECDH(public)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := ephemeral.PublicKey().Bytes()
+	shared, err := extractAndExpand(dh, append(append([]byte{}, enc...), public.Bytes()...))
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, err := keySchedule(shared, info)
+	return enc, ctx, err
+}
+
+// setupRecipient recovers the sender's context from enc
+func setupRecipient(enc []byte, private *ecdh.PrivateKey, info []byte) (*hpkeContext, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := extractAndExpand(dh, append(append([]byte{}, enc...), private.PublicKey().Bytes()...))
+	if err != nil {
+		return nil, err
+	}
+	return keySchedule(shared, info)
+}
+
+// extractAndExpand turns the Diffie-Hellman output into the KEM shared secret
+func extractAndExpand(dh, kemContext []byte) ([]byte, error) {
+	prk, err := labeledExtract(kemSuiteID, nil, "eae_prk", dh)
+	if err != nil {
+		return nil, err
+	}
+	return labeledExpand(kemSuiteID, prk, "shared_secret", kemContext, hpkeSecretLen)
+}
+
+func keySchedule(shared, info []byte) (*hpkeContext, error) {
+	pskIDHash, err := labeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)
+	if err != nil {
+		return nil, err
+	}
+	infoHash, err := labeledExtract(hpkeSuiteID, nil, "info_hash", info)
+	if err != nil {
+		return nil, err
+	}
+	context := append(append([]byte{hpkeModeBase}, pskIDHash...), infoHash...)
+
+	secret, err := labeledExtract(hpkeSuiteID, shared, "secret", nil)
+	if err != nil {
+		return nil, err
+	}
+	key, err := labeledExpand(hpkeSuiteID, secret, "key", context, aeadKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	baseNonce, err := labeledExpand(hpkeSuiteID, secret, "base_nonce", context, aeadNonce)
+	if err != nil {
+		return nil, err
+	}
+	exporterSecret, err := labeledExpand(hpkeSuiteID, secret, "exp", context, hpkeSecretLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &hpkeContext{aead: gcm, baseNonce: baseNonce, exporterSecret: exporterSecret}, nil
+}
+
+func (c *hpkeContext) seal(aad, plaintext []byte) ([]byte, error) {
+	nonce, err := c.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func (c *hpkeContext) open(aad, ciphertext []byte) ([]byte, error) {
+	nonce, err := c.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		// A failed open does not use up the sequence number (RFC 9180 section 5.2)
+		c.seq--
+	}
+	return plaintext, err
+}
+
+// nextNonce XORs the sequence number into the base nonce and advances it
+func (c *hpkeContext) nextNonce() ([]byte, error) {
+	if c.seq == ^uint64(0) {
+		return nil, errors.New("hpke message limit reached")
+	}
+	nonce := append([]byte{}, c.baseNonce...)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.seq)
+	for i := range seq {
+		nonce[len(nonce)-8+i] ^= seq[i]
+	}
+	c.seq++
+	return nonce, nil
+}
+
+// export derives a secret bound to the context, ODoH keys its response with one
+func (c *hpkeContext) export(exporterContext string, length int) ([]byte, error) {
+	return labeledExpand(hpkeSuiteID, c.exporterSecret, "sec", []byte(exporterContext), length)
+}
+
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) ([]byte, error) {
+	labeled := append(append(append([]byte("HPKE-v1"), suiteID...), label...), ikm...)
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+func labeledExpand(suiteID, prk []byte, label string, info []byte, length int) ([]byte, error) {
+	labeled := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeled = append(append(append(append(labeled, "HPKE-v1"...), suiteID...), label...), info...)
+	return hkdf.Expand(sha256.New, prk, string(labeled), length)
+}
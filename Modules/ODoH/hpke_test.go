@@ -0,0 +1,76 @@
+package ODoH
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 9180 appendix A.1.1: DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM, base mode
+func TestHPKEVector(t *testing.T) {
+	info := unhex(t, "4f6465206f6e2061204772656369616e2055726e")
+	skE, err := ecdh.X25519().NewPrivateKey(unhex(t, "52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skR, err := ecdh.X25519().NewPrivateKey(unhex(t, "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, "3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d"); !bytes.Equal(skR.PublicKey().Bytes(), want) {
+		t.Fatalf("recipient public key %x, want %x", skR.PublicKey().Bytes(), want)
+	}
+
+	enc, sender, err := setupSenderWithKey(skE, skR.PublicKey(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431"); !bytes.Equal(enc, want) {
+		t.Errorf("enc %x, want %x", enc, want)
+	}
+	if want := unhex(t, "56d890e5accaaf011cff4b7d"); !bytes.Equal(sender.baseNonce, want) {
+		t.Errorf("base_nonce %x, want %x", sender.baseNonce, want)
+	}
+	if want := unhex(t, "45ff1c2e220db587171952c0592d5f5ebe103f1561a2614e38f2ffd47e99e3f8"); !bytes.Equal(sender.exporterSecret, want) {
+		t.Errorf("exporter_secret %x, want %x", sender.exporterSecret, want)
+	}
+
+	pt := unhex(t, "4265617574792069732074727574682c20747275746820626561757479")
+	aad := unhex(t, "436f756e742d30")
+	ct, err := sender.seal(aad, pt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, "f938558b5d72f1a23810b4be2ab4f84331acc02fc97babc53a52ae8218a355a96d8770ac83d07bea87e13c512a"); !bytes.Equal(ct, want) {
+		t.Errorf("ciphertext %x, want %x", ct, want)
+	}
+	exported, err := sender.export("", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, "3853fe2b4035195a573ffc53856e77058e15d9ea064de3e59f4961d0095250ee"); !bytes.Equal(exported, want) {
+		t.Errorf("export %x, want %x", exported, want)
+	}
+
+	recipient, err := setupRecipient(enc, skR, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recipient.open(aad, append(append([]byte{}, ct[:len(ct)-1]...), ct[len(ct)-1]^1)); err == nil {
+		t.Error("a tampered ciphertext was opened")
+	}
+	opened, err := recipient.open(aad, ct)
+	if err != nil || !bytes.Equal(opened, pt) {
+		t.Errorf("open got %x, %v, want %x", opened, err, pt)
+	}
+}
@@ -0,0 +1,224 @@
+package ODoH
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+)
+
+const (
+	// MimeType is the content type of oblivious queries and responses
+	MimeType = "application/oblivious-dns-message"
+	// ConfigsPath is where a target publishes its ObliviousDoHConfigs
+	ConfigsPath = "/.well-known/odohconfigs"
+
+	configVersion = 0x0001
+
+	messageQuery    byte = 0x01
+	messageResponse byte = 0x02
+
+	// The mandatory suite: DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM
+	kemX25519  uint16 = 0x0020
+	kdfSHA256  uint16 = 0x0001
+	aeadAES128 uint16 = 0x0001
+	aeadKeyLen        = 16
+	aeadNonce         = 12
+	// max(Nn, Nk) for AES-128-GCM
+	responseNonceLen = 16
+)
+
+var odohLogger *Logger.ModuleLogger
+
+func init() {
+	var err error
+	odohLogger, err = Logger.GetLogger("ODoH")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for ODoH module:", err)
+	}
+}
+
+// Config is one ObliviousDoHConfigContents: the HPKE suite and public key of a target
+type Config struct {
+	KEM       uint16
+	KDF       uint16
+	AEAD      uint16
+	PublicKey []byte
+}
+
+func (c Config) contents() []byte {
+	out := binary.BigEndian.AppendUint16(nil, c.KEM)
+	out = binary.BigEndian.AppendUint16(out, c.KDF)
+	out = binary.BigEndian.AppendUint16(out, c.AEAD)
+	return appendOpaque(out, c.PublicKey)
+}
+
+// KeyID identifies the config in queries: Expand(Extract("", contents), "odoh key id", Nh)
+func (c Config) KeyID() []byte {
+	prk, _ := hkdf.Extract(sha256.New, c.contents(), nil)
+	id, _ := hkdf.Expand(sha256.New, prk, "odoh key id", sha256.Size)
+	return id
+}
+
+func (c Config) supported() bool {
+	return c.KEM == kemX25519 && c.KDF == kdfSHA256 && c.AEAD == aeadAES128
+}
+
+// MarshalConfigs serializes ObliviousDoHConfigs as served at ConfigsPath
+func MarshalConfigs(configs ...Config) []byte {
+	var list []byte
+	for _, c := range configs {
+		contents := c.contents()
+		list = binary.BigEndian.AppendUint16(list, configVersion)
+		list = appendOpaque(list, contents)
+	}
+	return appendOpaque(nil, list)
+}
+
+// ParseConfigs returns the configs a client can use, unknown versions and suites are skipped
+func ParseConfigs(data []byte) ([]Config, error) {
+	list, rest, err := readOpaque(data)
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed ObliviousDoHConfigs")
+	}
+
+	var configs []Config
+	for len(list) > 0 {
+		if len(list) < 2 {
+			return nil, errors.New("malformed ObliviousDoHConfig")
+		}
+		version := binary.BigEndian.Uint16(list)
+		var contents []byte
+		if contents, list, err = readOpaque(list[2:]); err != nil {
+			return nil, err
+		}
+		if version != configVersion || len(contents) < 6 {
+			continue
+		}
+		c := Config{
+			KEM:  binary.BigEndian.Uint16(contents),
+			KDF:  binary.BigEndian.Uint16(contents[2:]),
+			AEAD: binary.BigEndian.Uint16(contents[4:]),
+		}
+		if c.PublicKey, _, err = readOpaque(contents[6:]); err != nil {
+			return nil, err
+		}
+		if c.supported() {
+			configs = append(configs, c)
+		}
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("no supported ObliviousDoHConfig")
+	}
+	return configs, nil
+}
+
+// message is an ObliviousDoHMessage; for responses keyID carries the response nonce
+type message struct {
+	kind  byte
+	keyID []byte
+	body  []byte
+}
+
+func (m message) marshal() []byte {
+	out := appendOpaque([]byte{m.kind}, m.keyID)
+	return appendOpaque(out, m.body)
+}
+
+func parseMessage(data []byte) (message, error) {
+	if len(data) < 1 {
+		return message{}, errors.New("empty message")
+	}
+	m := message{kind: data[0]}
+	var err error
+	rest := data[1:]
+	if m.keyID, rest, err = readOpaque(rest); err != nil {
+		return m, err
+	}
+	if m.body, rest, err = readOpaque(rest); err != nil {
+		return m, err
+	}
+	if len(rest) != 0 || len(m.body) == 0 {
+		return m, errors.New("malformed ObliviousDoHMessage")
+	}
+	return m, nil
+}
+
+// aad binds the message type and key ID (or response nonce) to the cipher text
+func aad(kind byte, keyID []byte) []byte {
+	return appendOpaque([]byte{kind}, keyID)
+}
+
+// plaintext is ObliviousDoHMessagePlaintext: the DNS message and zero padding
+func plaintext(dnsMsg []byte, padding int) []byte {
+	out := appendOpaque(nil, dnsMsg)
+	return appendOpaque(out, make([]byte, padding))
+}
+
+func parsePlaintext(data []byte) ([]byte, error) {
+	dnsMsg, rest, err := readOpaque(data)
+	if err != nil || len(dnsMsg) == 0 {
+		return nil, errors.New("malformed ObliviousDoHMessagePlaintext")
+	}
+	padding, rest, err := readOpaque(rest)
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed ObliviousDoHMessagePlaintext")
+	}
+	for _, b := range padding {
+		if b != 0 {
+			return nil, errors.New("non-zero padding")
+		}
+	}
+	return dnsMsg, nil
+}
+
+// responseAEAD derives the response key and nonce from the query's HPKE context (RFC 9230 section 6.4)
+func responseAEAD(secret, queryPlain, responseNonce []byte) (cipher.AEAD, []byte, error) {
+	salt := appendOpaque(append([]byte{}, queryPlain...), responseNonce)
+	prk, err := hkdf.Extract(sha256.New, secret, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := hkdf.Expand(sha256.New, prk, "odoh key", aeadKeyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "odoh nonce", aeadNonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	return gcm, nonce, err
+}
+
+func randomNonce() ([]byte, error) {
+	nonce := make([]byte, responseNonceLen)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
+
+func appendOpaque(out, data []byte) []byte {
+	out = binary.BigEndian.AppendUint16(out, uint16(len(data)))
+	return append(out, data...)
+}
+
+func readOpaque(data []byte) (value, rest []byte, err error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("truncated field")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, errors.New("truncated field")
+	}
+	return data[2 : 2+n], data[2+n:], nil
+}
@@ -0,0 +1,205 @@
+package ODoH_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/DoH"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/ODoH"
+)
+
+// writeCertificate creates a self-signed certificate for 127.0.0.1 in dir
+func writeCertificate(t *testing.T, dir string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "odoh.test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// freeAddr finds a free loopback port for a DoH listener
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startDoH runs a DoH listener built from conf, which decides whether it is a target or a proxy
+func startDoH(t *testing.T, conf *Loader.Config, addr, certPath, keyPath string) {
+	t.Helper()
+	Loader.Use(conf)
+	srv, err := DoH.NewDoHServer(addr, DoH.DefaultPath, certPath, keyPath, "")
+	if err != nil {
+		t.Fatalf("NewDoHServer: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+	})
+}
+
+// startPair runs a target and a proxy allowed to reach it, both on loopback
+func startPair(t *testing.T) (client *ODoH.Client, targetAddr string) {
+	t.Helper()
+	dir := t.TempDir()
+	certPath, keyPath := writeCertificate(t, dir)
+	targetAddr, proxyAddr := freeAddr(t), freeAddr(t)
+
+	target := new(Loader.Config)
+	target.ODoH.Target.Enabled = true
+	target.ODoH.Target.KeyFile = filepath.Join(dir, "odoh-target.pem")
+	startDoH(t, target, targetAddr, certPath, keyPath)
+
+	proxy := new(Loader.Config)
+	proxy.ODoH.Proxy.Enabled = true
+	proxy.ODoH.Proxy.Path = "/proxy"
+	proxy.ODoH.Proxy.AllowedTargets = []string{targetAddr}
+	proxy.ODoH.Proxy.CAFile = certPath
+	proxy.ODoH.Proxy.Timeout = 5
+	startDoH(t, proxy, proxyAddr, certPath, keyPath)
+
+	// Both instances share this process, so the settings read per request must cover both roles
+	both := *proxy
+	both.ODoH.Target = target.ODoH.Target
+	Loader.Use(&both)
+
+	pem, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	return &ODoH.Client{
+		HTTP:       &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}},
+		TargetHost: targetAddr,
+		TargetPath: DoH.DefaultPath,
+		ProxyURL:   "https://" + proxyAddr + "/proxy",
+	}, targetAddr
+}
+
+// A query outside resolver.arpa would need the network, this one is answered locally
+func localQuery() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("_dns.resolver.arpa.", dns.TypeSVCB)
+	return m
+}
+
+func TestQueryThroughProxy(t *testing.T) {
+	client, _ := startPair(t)
+	config, err := client.FetchConfig()
+	if err != nil {
+		t.Fatalf("FetchConfig: %v", err)
+	}
+
+	query := localQuery()
+	resp, err := client.Exchange(config, query)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if resp.Id != query.Id || resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("got ID %d rcode %s, want ID %d NOERROR", resp.Id, dns.RcodeToString[resp.Rcode], query.Id)
+	}
+}
+
+func TestProxyRefusesOtherTargets(t *testing.T) {
+	client, _ := startPair(t)
+	config, err := client.FetchConfig()
+	if err != nil {
+		t.Fatalf("FetchConfig: %v", err)
+	}
+
+	client.TargetHost = freeAddr(t)
+	if _, err := client.Exchange(config, localQuery()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("query for a target outside allowed_targets got %v, want 403 Forbidden", err)
+	}
+}
+
+func TestStaleKeyIDIsRejected(t *testing.T) {
+	client, _ := startPair(t)
+	config, err := client.FetchConfig()
+	if err != nil {
+		t.Fatalf("FetchConfig: %v", err)
+	}
+
+	// Another key for the same suite, as after the target rotated its key
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.PublicKey = other.PublicKey().Bytes()
+	if _, err := client.Exchange(config, localQuery()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("query under an unknown key got %v, want 401 Unauthorized", err)
+	}
+}
+
+func TestTargetUsesItsKeyFile(t *testing.T) {
+	first, _ := startPair(t)
+	before, err := first.FetchConfig()
+	if err != nil {
+		t.Fatalf("FetchConfig: %v", err)
+	}
+
+	// The second target is set up with a new key file, which replaces the key in use
+	second, _ := startPair(t)
+	after, err := second.FetchConfig()
+	if err != nil {
+		t.Fatalf("FetchConfig: %v", err)
+	}
+	if string(after.PublicKey) == string(before.PublicKey) {
+		t.Fatal("target kept the key of an earlier key file")
+	}
+	if _, err := second.Exchange(after, localQuery()); err != nil {
+		t.Errorf("Exchange under the new key: %v", err)
+	}
+}
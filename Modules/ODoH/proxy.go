@@ -0,0 +1,149 @@
+package ODoH
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+)
+
+var (
+	proxyRequests = Metrics.NewCounterVec("hopzero_odoh_proxy_requests_total", "Oblivious queries relayed as a proxy, by result", "result")
+
+	proxyClient *http.Client
+)
+
+// InitProxy prepares the HTTPS client used to reach targets
+func InitProxy() error {
+	conf := Loader.Current().ODoH.Proxy
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.CAFile != "" {
+		pem, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return fmt.Errorf("odoh proxy ca_file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("odoh proxy ca_file %s holds no certificates", conf.CAFile)
+		}
+	}
+
+	proxyClient = &http.Client{
+		Timeout: time.Duration(conf.Timeout) * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   90 * time.Second,
+		},
+		// A target never redirects an oblivious query, following one could leak it elsewhere
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	odohLogger.Info(fmt.Sprintf("🔀 ODoH proxy relaying to %d allowed target(s)", len(conf.AllowedTargets)))
+	return nil
+}
+
+// ServeProxy relays an oblivious query to the target named by targethost and targetpath.
+// Nothing about the client is passed on, the target only sees the proxy.
+func ServeProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != MimeType {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	switch Access.Check(Access.FromHTTP(r)) {
+	case Access.DeniedACL:
+		proxyRequests.With("denied").Inc()
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	case Access.DeniedRateLimit:
+		proxyRequests.With("rate_limited").Inc()
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+
+	host := r.URL.Query().Get("targethost")
+	path := r.URL.Query().Get("targetpath")
+	if host == "" || !strings.HasPrefix(path, "/") {
+		proxyRequests.With("bad_request").Inc()
+		http.Error(w, "targethost and targetpath are required", http.StatusBadRequest)
+		return
+	}
+	if !targetAllowed(host) {
+		proxyRequests.With("target_denied").Inc()
+		http.Error(w, "target not allowed", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil || len(body) > maxMessageSize {
+		proxyRequests.With("bad_request").Inc()
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	target := (&url.URL{Scheme: "https", Host: host, Path: path}).String()
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		proxyRequests.With("bad_request").Inc()
+		http.Error(w, "invalid target", http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", MimeType)
+	req.Header.Set("Accept", MimeType)
+
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		proxyRequests.With("target_error").Inc()
+		odohLogger.Warn(fmt.Sprintf("⚠️ ODoH target %s unreachable: %v", host, err))
+		http.Error(w, "target unreachable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize+1))
+	if err != nil || len(answer) > maxMessageSize {
+		proxyRequests.With("target_error").Inc()
+		http.Error(w, "invalid target response", http.StatusBadGateway)
+		return
+	}
+
+	// Client errors from the target (stale key ID, undecryptable query) go back as they are
+	if resp.StatusCode != http.StatusOK && (resp.StatusCode < 400 || resp.StatusCode >= 500) {
+		proxyRequests.With("target_error").Inc()
+		http.Error(w, "target failed", http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode == http.StatusOK {
+		proxyRequests.With("ok").Inc()
+	} else {
+		proxyRequests.With("target_rejected").Inc()
+	}
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(answer)
+}
+
+// targetAllowed matches targethost exactly against the configured list, case-insensitively
+func targetAllowed(host string) bool {
+	for _, allowed := range Loader.Current().ODoH.Proxy.AllowedTargets {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
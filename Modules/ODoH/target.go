@@ -0,0 +1,204 @@
+package ODoH
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
+)
+
+// Encrypted query, HPKE encapsulated key and AEAD overhead on top of a DNS message
+const maxMessageSize = dns.MaxMsgSize + 512
+
+var (
+	targetQueries = Metrics.NewCounterVec("hopzero_odoh_target_queries_total", "Oblivious queries handled as a target, by result", "result")
+
+	currentTarget atomic.Pointer[targetState]
+)
+
+// targetState is the HPKE key a target decrypts with and the configs it publishes for it
+type targetState struct {
+	key         *ecdh.PrivateKey
+	config      Config
+	configsWire []byte
+	keyID       []byte
+}
+
+// InitTarget loads the target's HPKE key, creating it on first start. Every call reads the
+// key file again and replaces the key in use.
+func InitTarget() error {
+	path := Loader.Current().ODoH.Target.KeyFile
+	key, err := loadKey(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err = generateKey(path)
+		if err == nil {
+			odohLogger.Info("🔑 Generated a new ODoH target key in " + path)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("odoh target key: %w", err)
+	}
+
+	state := &targetState{key: key}
+	state.config = Config{KEM: kemX25519, KDF: kdfSHA256, AEAD: aeadAES128, PublicKey: key.PublicKey().Bytes()}
+	state.configsWire = MarshalConfigs(state.config)
+	state.keyID = state.config.KeyID()
+	currentTarget.Store(state)
+	odohLogger.Info(fmt.Sprintf("🕶️ ODoH target ready, key ID %x", state.keyID[:8]))
+	return nil
+}
+
+// ServeConfigs publishes the target's ObliviousDoHConfigs
+func ServeConfigs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	_, _ = w.Write(currentTarget.Load().configsWire)
+}
+
+// ServeTarget decrypts an oblivious query, resolves it and encrypts the answer for the client.
+// The request comes from a proxy, so the access checks apply to the proxy, not the client.
+func ServeTarget(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil || len(body) > maxMessageSize {
+		targetQueries.With("malformed").Inc()
+		http.Error(w, "malformed oblivious message", http.StatusBadRequest)
+		return
+	}
+	msg, err := parseMessage(body)
+	if err != nil || msg.kind != messageQuery {
+		targetQueries.With("malformed").Inc()
+		http.Error(w, "malformed oblivious message", http.StatusBadRequest)
+		return
+	}
+	// An unknown key ID tells the client to fetch the configs again
+	state := currentTarget.Load()
+	if !bytes.Equal(msg.keyID, state.keyID) {
+		targetQueries.With("unknown_key").Inc()
+		http.Error(w, "unknown key ID", http.StatusUnauthorized)
+		return
+	}
+
+	encSize := len(state.config.PublicKey)
+	if len(msg.body) <= encSize {
+		targetQueries.With("malformed").Inc()
+		http.Error(w, "malformed oblivious message", http.StatusBadRequest)
+		return
+	}
+	recipient, err := setupRecipient(msg.body[:encSize], state.key, []byte("odoh query"))
+	var queryPlain, wire []byte
+	if err == nil {
+		queryPlain, err = recipient.open(aad(messageQuery, msg.keyID), msg.body[encSize:])
+	}
+	if err == nil {
+		wire, err = parsePlaintext(queryPlain)
+	}
+	req := new(dns.Msg)
+	if err == nil {
+		err = req.Unpack(wire)
+	}
+	if err != nil {
+		targetQueries.With("decrypt").Inc()
+		http.Error(w, "cannot decrypt query", http.StatusBadRequest)
+		return
+	}
+
+	resp := Pipeline.Serve(Access.FromHTTP(r), req)
+	packed, err := resp.Pack()
+	if err != nil {
+		odohLogger.Error("❌ Failed to pack DNS response: " + err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sealed, err := sealResponse(recipient, queryPlain, packed)
+	if err != nil {
+		odohLogger.Error("❌ Failed to encrypt oblivious response: " + err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	targetQueries.With("ok").Inc()
+	w.Header().Set("Content-Type", MimeType)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	_, _ = w.Write(sealed)
+}
+
+// sealResponse encrypts the answer under keys only the querying client can derive.
+// The plaintext is padded to the same block size as other encrypted responses.
+func sealResponse(recipient *hpkeContext, queryPlain, packed []byte) ([]byte, error) {
+	secret, err := recipient.export("odoh response", aeadKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+	gcm, aeadNonce, err := responseAEAD(secret, queryPlain, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := (Padding.ResponseBlock - len(packed)%Padding.ResponseBlock) % Padding.ResponseBlock
+	ct := gcm.Seal(nil, aeadNonce, plaintext(packed, padding), aad(messageResponse, nonce))
+	return message{kind: messageResponse, keyID: nonce, body: ct}.marshal(), nil
+}
+
+func generateKey(path string) (*ecdh.PrivateKey, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return key, pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func loadKey(path string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in %s: %w", path, err)
+	}
+	key, ok := parsed.(*ecdh.PrivateKey)
+	if !ok || key.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("key in %s is not X25519", path)
+	}
+	return key, nil
+}
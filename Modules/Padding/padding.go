@@ -0,0 +1,55 @@
+package Padding
+
+import "github.com/miekg/dns"
+
+// Block lengths recommended by RFC 8467 section 4.1
+const (
+	QueryBlock    = 128
+	ResponseBlock = 468
+)
+
+// Size of an EDNS option header (code and length)
+const optionHeader = 4
+
+// Requested reports whether a query carries the EDNS(0) Padding option (RFC 7830)
+func Requested(m *dns.Msg) bool {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0PADDING {
+			return true
+		}
+	}
+	return false
+}
+
+// Pad grows the message with a Padding option until its wire size is a multiple of block.
+// Messages without an OPT record cannot carry padding and are left alone.
+func Pad(m *dns.Msg, block int) {
+	Strip(m)
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	size := m.Len() + optionHeader
+	padding := (block - size%block) % block
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padding)})
+}
+
+// Strip removes Padding options, padding is hop-by-hop and never forwarded
+func Strip(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0PADDING {
+			options = append(options, o)
+		}
+	}
+	opt.Option = options
+}
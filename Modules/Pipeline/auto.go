@@ -0,0 +1,45 @@
+package Pipeline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Forwarder"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Policy"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Resolver"
+)
+
+// auto lets the policy engine choose between recursion and the default forwarder group.
+// Failed recursions feed the engine and fall back to the forwarder when it is healthy.
+func auto(r *dns.Msg, m *dns.Msg, do bool, group *Forwarder.Group) *dns.Msg {
+	q := r.Question[0]
+	if fromCache(r, m, do) {
+		return m
+	}
+
+	decision := Policy.Evaluate(q, do, r.CheckingDisabled)
+	if decision.Route == Policy.RouteForward {
+		return forwardQuery(r, m, do, group, false)
+	}
+
+	start := time.Now()
+	answers, err := Resolver.Resolve(q, do, r.CheckingDisabled)
+	if errors.Is(err, Resolver.ErrClassNotImplemented) {
+		m.Rcode = dns.RcodeNotImplemented
+		return m
+	}
+	Policy.ObserveRecursion(q.Name, time.Since(start), err)
+	if err != nil {
+		if decision.Fallback && group.Healthy() {
+			pipelineLogger.Warn(fmt.Sprintf("🔁 Recursion failed for %s, falling back to the forwarder: %v", q.Name, err))
+			return forwardQuery(r, m, do, group, false)
+		}
+		pipelineLogger.Warn(fmt.Sprintf("❌ Failed to resolve %s: %v", q.Name, err))
+		m.Rcode = dns.RcodeServerFailure
+		return m
+	}
+	m.Answer = answers
+	return m
+}
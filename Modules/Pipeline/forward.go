@@ -0,0 +1,69 @@
+package Pipeline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Cache"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Forwarder"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+)
+
+// forward answers a query from a forwarder group instead of recursing, m is the prepared reply.
+// For insecure zones AD is never set, whatever the upstream claims.
+func forward(r *dns.Msg, m *dns.Msg, do bool, group *Forwarder.Group, insecure bool) *dns.Msg {
+	q := r.Question[0]
+	pipelineLogger.Info(fmt.Sprintf("📨 Received query for %s (%s), forwarding", q.Name, dns.TypeToString[q.Qtype]))
+
+	if fromCache(r, m, do) {
+		return m
+	}
+	return forwardQuery(r, m, do, group, insecure)
+}
+
+// fromCache fills m from a cached answer set, reporting whether there was one
+func fromCache(r *dns.Msg, m *dns.Msg, do bool) bool {
+	entry, ok := Cache.LookupRR(r.Question[0], do, r.CheckingDisabled)
+	if !ok {
+		return false
+	}
+	m.Answer = entry.Records(time.Now())
+	m.Rcode = entry.Rcode
+	m.AuthenticatedData = entry.Secure && do
+	return true
+}
+
+// forwardQuery sends the query to the group and copies the upstream's reply into m
+func forwardQuery(r *dns.Msg, m *dns.Msg, do bool, group *Forwarder.Group, insecure bool) *dns.Msg {
+	q := r.Question[0]
+	query := r.Copy()
+	query.RecursionDesired = true
+	Padding.Strip(query)
+	resp, err := group.Exchange(query)
+	if err != nil {
+		pipelineLogger.Warn(fmt.Sprintf("❌ Failed to forward %s: %v", q.Name, err))
+		m.Rcode = dns.RcodeServerFailure
+		return m
+	}
+
+	m.Rcode = resp.Rcode
+	m.Answer = resp.Answer
+	m.Ns = resp.Ns
+	secure := resp.AuthenticatedData && !insecure
+	m.AuthenticatedData = secure && do
+	// Keep the upstream's additional records but not its OPT, ours is already set
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			m.Extra = append(m.Extra, rr)
+		}
+	}
+
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 {
+		entry := Cache.NewEntry(resp.Answer, resp.Rcode, secure)
+		if err := Cache.StoreRR(q, do, r.CheckingDisabled, entry); err != nil {
+			pipelineLogger.Warn(fmt.Sprintf("⚠️ Failed to cache forwarded answer for %s: %v", q.Name, err))
+		}
+	}
+	return m
+}
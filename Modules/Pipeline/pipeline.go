@@ -0,0 +1,168 @@
+package Pipeline
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Discovery"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Forwarder"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Padding"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Resolver"
+)
+
+// EDNS buffer size advertised in replies (DNS flag day 2020)
+const ednsBufferSize = 1232
+
+var pipelineLogger *Logger.ModuleLogger
+
+func init() {
+	var err error
+	pipelineLogger, err = Logger.GetLogger("Pipeline")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Pipeline module:", err)
+	}
+}
+
+// Answer runs a query through the resolver and builds the reply, whatever transport it came in on
+func Answer(r *dns.Msg) *dns.Msg {
+	return answer(r, Loader.Current().Resolver.Mode)
+}
+
+// answer resolves r in the given resolver mode, views may override the global one
+func answer(r *dns.Msg, mode string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.RecursionAvailable = true
+
+	opt := r.IsEdns0()
+	do := opt != nil && opt.Do()
+	if opt != nil {
+		m.SetEdns0(ednsBufferSize, do)
+	}
+
+	if len(r.Question) == 0 {
+		pipelineLogger.Error("📛 Invalid DNS query format: no question in query")
+		m.Rcode = dns.RcodeFormatError
+		return m
+	}
+
+	// resolver.arpa and the resolver's own _dns name are answered here, never resolved
+	if answer, extra, rcode, ok := Discovery.Answer(r.Question[0]); ok {
+		m.Answer = answer
+		m.Extra = append(m.Extra, extra...)
+		m.Rcode = rcode
+		return m
+	}
+
+	// Forward zones and forward mode pass the upstream's reply through, including NXDOMAIN and authority records
+	zone := Resolver.MatchZone(r.Question[0].Name)
+	if zone != nil && zone.Type == Resolver.ZoneForward {
+		return forward(r, m, do, zone.Group, zone.Insecure)
+	}
+	if group := Forwarder.Default(); zone == nil && group != nil {
+		switch mode {
+		case "forward":
+			return forward(r, m, do, group, false)
+		case "auto":
+			return auto(r, m, do, group)
+		}
+	}
+
+	// Process each question (e.g., for A, AAAA records)
+	for _, q := range r.Question {
+		pipelineLogger.Info(fmt.Sprintf("📨 Received query for %s (%s)", q.Name, dns.TypeToString[q.Qtype]))
+
+		answers, err := Resolver.Resolve(q, do, r.CheckingDisabled)
+		if err != nil {
+			pipelineLogger.Warn(fmt.Sprintf("❌ Failed to resolve %s: %v", q.Name, err))
+			m.Answer = nil
+			m.Rcode = failureRcode(err)
+			return m
+		}
+		m.Answer = append(m.Answer, answers...)
+	}
+	return m
+}
+
+// failureRcode tells a client why resolution failed: NOTIMP for a class the resolver does
+// not follow, SERVFAIL for everything else
+func failureRcode(err error) int {
+	if errors.Is(err, Resolver.ErrClassNotImplemented) {
+		return dns.RcodeNotImplemented
+	}
+	return dns.RcodeServerFailure
+}
+
+// Serve answers a query for an identified client, after the ACL and rate limit let it through
+func Serve(client Access.Client, r *dns.Msg) *dns.Msg {
+	if reason := Access.Check(client); reason != "" {
+		return Refused(r, reason)
+	}
+	if len(r.Question) > 0 {
+		pipelineLogger.Info(fmt.Sprintf("👤 %s asked for %s (%s)", client, r.Question[0].Name, dns.TypeToString[r.Question[0].Qtype]))
+	}
+	conf := Loader.Current()
+	mode := conf.Resolver.Mode
+	if view := conf.FindView(client.View); view != nil && view.ResolverMode != "" {
+		mode = view.ResolverMode
+	}
+	return answer(r, mode)
+}
+
+// Refused tells a client it is not allowed to query, with an extended error when it speaks EDNS
+func Refused(r *dns.Msg, reason string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeRefused)
+	m.RecursionAvailable = true
+	if r.IsEdns0() != nil {
+		m.SetEdns0(ednsBufferSize, false)
+		ede := &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeProhibited}
+		if reason == Access.DeniedRateLimit {
+			ede = &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeOther, ExtraText: "rate limited"}
+		}
+		m.IsEdns0().Option = append(m.IsEdns0().Option, ede)
+	}
+	return m
+}
+
+// Handler answers queries for a miekg/dns based listener attached to view
+func Handler(view string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		serveDNS(view, w, r)
+	})
+}
+
+func serveDNS(view string, w dns.ResponseWriter, r *dns.Msg) {
+	resp := Serve(Access.FromWriter(w).InView(view), r)
+	Truncate(w, r, resp)
+	if encrypted(w) && Padding.Requested(r) {
+		Padding.Pad(resp, Padding.ResponseBlock)
+	}
+
+	if err := w.WriteMsg(resp); err != nil {
+		pipelineLogger.Warn("⚠️ Failed to send response back to client: " + err.Error())
+	}
+}
+
+// encrypted reports whether a miekg/dns listener connection is TLS
+func encrypted(w dns.ResponseWriter) bool {
+	stater, ok := w.(dns.ConnectionStater)
+	return ok && stater.ConnectionState() != nil
+}
+
+// Truncate fits a UDP reply into the client's buffer, TC tells it to retry over TCP
+func Truncate(w dns.ResponseWriter, r *dns.Msg, resp *dns.Msg) {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); !ok {
+		return
+	}
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
+	resp.Truncate(size)
+}
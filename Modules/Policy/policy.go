@@ -0,0 +1,154 @@
+package Policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Forwarder"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+)
+
+// Routes a query can take in resolver mode auto
+const (
+	RouteRecurse = "recurse"
+	RouteForward = "forward"
+)
+
+// Signals is everything a decision is based on. Evaluate fills it from live
+// state; callers can also build one by hand to simulate latencies and failures.
+type Signals struct {
+	Rule                 string        // suffix of the matching configured rule, empty if none
+	RuleRoute            string        // route forced by that rule
+	ForwarderHealthy     bool          // at least one default forwarder upstream is up
+	ForwarderLatency     time.Duration // best smoothed forwarder latency, 0 if not measured
+	RecursionLatency     time.Duration // smoothed recursion latency for the zone, 0 if not measured
+	RecursionFailureRate float64       // smoothed share of failed recursions for the zone
+	RecursionSamples     int           // recursions observed for the zone
+	Zone                 string        // zone the recursion statistics belong to
+	SinceRecursion       time.Duration // time since the zone's last recursion, 0 if never
+	DNSSECRequested      bool          // client set DO without CD
+}
+
+// Decision is the chosen route and a human readable reason for the logs.
+// Fallback allows forwarding when a chosen recursion fails, rules turn it off.
+type Decision struct {
+	Route    string
+	Reason   string
+	Fallback bool
+}
+
+// Thresholds tune the engine, they mirror resolver.policy in Config.yaml
+type Thresholds struct {
+	MaxFailureRate   float64
+	MinSamples       int
+	LatencyFactor    float64
+	DNSSECPrefersRec bool
+	RetryAfter       time.Duration // a zone routed away from recursion tries it again after this
+}
+
+// Zones forwarded for their recursion statistics retry recursion this often so they can recover
+const recursionRetry = 30 * time.Second
+
+var (
+	policyLogger *Logger.ModuleLogger
+
+	decisions = Metrics.NewCounterVec("hopzero_policy_decisions_total", "Resolver mode auto routing decisions", "route")
+)
+
+func init() {
+	var err error
+	policyLogger, err = Logger.GetLogger("Policy")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Policy module:", err)
+	}
+}
+
+// Configured returns the thresholds from the loaded config
+func Configured() Thresholds {
+	conf := Loader.Current().Resolver.Policy
+	return Thresholds{
+		MaxFailureRate:   conf.MaxFailureRate,
+		MinSamples:       conf.MinSamples,
+		LatencyFactor:    conf.LatencyFactor,
+		DNSSECPrefersRec: conf.DNSSECPrefersRecursion,
+		RetryAfter:       recursionRetry,
+	}
+}
+
+// Decide picks a route from the signals. Rules win, then forwarder health,
+// then recursion failures, then DNSSEC, then latency; recursion is the default.
+func Decide(s Signals, t Thresholds) Decision {
+	if s.Rule != "" {
+		return Decision{s.RuleRoute, fmt.Sprintf("rule for %s", s.Rule), false}
+	}
+	if !s.ForwarderHealthy {
+		return Decision{RouteRecurse, "no healthy forwarder upstream", false}
+	}
+
+	// Statistics only change when recursion is used, so retry it once in a while
+	if s.RecursionSamples >= t.MinSamples && s.SinceRecursion >= t.RetryAfter {
+		return Decision{RouteRecurse, fmt.Sprintf("retrying recursion for %s after %s", s.Zone, round(s.SinceRecursion)), true}
+	}
+
+	failing := s.RecursionSamples >= t.MinSamples && s.RecursionFailureRate >= t.MaxFailureRate
+	if failing {
+		return Decision{RouteForward, fmt.Sprintf("recursion failure rate %.0f%% for %s over %d samples", s.RecursionFailureRate*100, s.Zone, s.RecursionSamples), true}
+	}
+
+	// Recursion validates locally, forwarders may not
+	if s.DNSSECRequested && t.DNSSECPrefersRec {
+		return Decision{RouteRecurse, "DNSSEC requested, validating locally", true}
+	}
+
+	if s.RecursionSamples >= t.MinSamples && s.RecursionLatency > 0 && s.ForwarderLatency > 0 &&
+		float64(s.RecursionLatency) > t.LatencyFactor*float64(s.ForwarderLatency) {
+		return Decision{RouteForward, fmt.Sprintf("recursion %s for %s vs forwarder %s", round(s.RecursionLatency), s.Zone, round(s.ForwarderLatency)), true}
+	}
+	return Decision{RouteRecurse, "recursion healthy", true}
+}
+
+// Evaluate gathers live signals for a query, decides and logs the reason
+func Evaluate(q dns.Question, do, cd bool) Decision {
+	s := Signals{DNSSECRequested: do && !cd}
+
+	if suffix, route, ok := matchRule(q.Name); ok {
+		s.Rule, s.RuleRoute = suffix, route
+	}
+	if group := Forwarder.Default(); group != nil {
+		s.ForwarderHealthy = group.Healthy()
+		s.ForwarderLatency = group.Latency()
+	}
+	s.Zone = statsZone(q.Name)
+	if st, ok := stats.get(s.Zone); ok {
+		s.RecursionLatency = st.latency
+		s.RecursionFailureRate = st.failureRate
+		s.RecursionSamples = st.samples
+		s.SinceRecursion = time.Since(st.updated)
+	}
+
+	d := Decide(s, Configured())
+	decisions.With(d.Route).Inc()
+	policyLogger.Info(fmt.Sprintf("🧠 %s %s -> %s: %s", q.Name, dns.TypeToString[q.Qtype], d.Route, d.Reason))
+	return d
+}
+
+// matchRule finds the longest configured rule suffix covering name
+func matchRule(name string) (string, string, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	best, route := "", ""
+	for _, rule := range Loader.Current().Resolver.Policy.Rules {
+		suffix := strings.ToLower(dns.Fqdn(rule.Suffix))
+		if dns.IsSubDomain(suffix, name) && len(suffix) > len(best) {
+			best, route = suffix, rule.Route
+		}
+	}
+	return best, route, best != ""
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,118 @@
+package Policy
+
+import (
+	"testing"
+	"time"
+)
+
+var thresholds = Thresholds{
+	MaxFailureRate:   0.5,
+	MinSamples:       10,
+	LatencyFactor:    3,
+	DNSSECPrefersRec: true,
+	RetryAfter:       recursionRetry,
+}
+
+func TestDecide(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		signals  Signals
+		route    string
+		fallback bool
+	}{
+		{
+			name:    "rule wins over every signal",
+			signals: Signals{Rule: "cdn.example.", RuleRoute: RouteForward, RecursionSamples: 50, RecursionLatency: 5 * ms, ForwarderLatency: 100 * ms},
+			route:   RouteForward,
+		},
+		{
+			name:    "no healthy forwarder recurses",
+			signals: Signals{RecursionSamples: 50, RecursionFailureRate: 1, ForwarderLatency: 10 * ms},
+			route:   RouteRecurse,
+		},
+		{
+			name:     "healthy recursion is the default",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionLatency: 20 * ms, ForwarderLatency: 10 * ms},
+			route:    RouteRecurse,
+			fallback: true,
+		},
+		{
+			name:     "recursion three times slower forwards",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionLatency: 310 * ms, ForwarderLatency: 100 * ms},
+			route:    RouteForward,
+			fallback: true,
+		},
+		{
+			name:     "recursion exactly at the latency factor recurses",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionLatency: 300 * ms, ForwarderLatency: 100 * ms},
+			route:    RouteRecurse,
+			fallback: true,
+		},
+		{
+			name:     "slow recursion below min_samples recurses",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 9, RecursionLatency: time.Second, ForwarderLatency: 10 * ms},
+			route:    RouteRecurse,
+			fallback: true,
+		},
+		{
+			name:     "unmeasured forwarder latency recurses",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionLatency: time.Second},
+			route:    RouteRecurse,
+			fallback: true,
+		},
+		{
+			name:     "failing recursion forwards",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 10, RecursionFailureRate: 0.5, RecursionLatency: 5 * ms, ForwarderLatency: 100 * ms},
+			route:    RouteForward,
+			fallback: true,
+		},
+		{
+			name:     "failing recursion forwards even with DNSSEC requested",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 10, RecursionFailureRate: 0.9, DNSSECRequested: true},
+			route:    RouteForward,
+			fallback: true,
+		},
+		{
+			name:     "DNSSEC requested recurses despite slow recursion",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionLatency: time.Second, ForwarderLatency: 10 * ms, DNSSECRequested: true},
+			route:    RouteRecurse,
+			fallback: true,
+		},
+		{
+			name:     "forwarded zone retries recursion after a while",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionFailureRate: 1, SinceRecursion: recursionRetry},
+			route:    RouteRecurse,
+			fallback: true,
+		},
+		{
+			name:     "forwarded zone keeps forwarding until the retry",
+			signals:  Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionFailureRate: 1, SinceRecursion: recursionRetry - time.Second},
+			route:    RouteForward,
+			fallback: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Decide(tt.signals, thresholds)
+			if d.Route != tt.route || d.Fallback != tt.fallback {
+				t.Errorf("got %s (fallback %t, %s), want %s (fallback %t)", d.Route, d.Fallback, d.Reason, tt.route, tt.fallback)
+			}
+			if d.Reason == "" {
+				t.Error("decision has no reason")
+			}
+		})
+	}
+}
+
+func TestDecideWithoutDNSSECPreference(t *testing.T) {
+	ms := time.Millisecond
+	noPreference := thresholds
+	noPreference.DNSSECPrefersRec = false
+
+	s := Signals{ForwarderHealthy: true, RecursionSamples: 50, RecursionLatency: time.Second, ForwarderLatency: 10 * ms, DNSSECRequested: true}
+	if d := Decide(s, noPreference); d.Route != RouteForward {
+		t.Errorf("got %s (%s), want forward on latency", d.Route, d.Reason)
+	}
+}
@@ -0,0 +1,85 @@
+package Policy
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Zones tracked at once, an arbitrary zone is dropped when a new one arrives past this
+const maxTrackedZones = 10000
+
+// Weight of a new sample in the moving averages
+const sampleWeight = 0.1
+
+type zoneStats struct {
+	latency     time.Duration
+	failureRate float64
+	samples     int
+	updated     time.Time
+}
+
+type statsTable struct {
+	mu    sync.Mutex
+	zones map[string]zoneStats
+}
+
+var stats = &statsTable{zones: make(map[string]zoneStats)}
+
+func (t *statsTable) get(zone string) (zoneStats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.zones[zone]
+	return st, ok
+}
+
+func (t *statsTable) observe(zone string, rtt time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.zones[zone]
+	if !ok && len(t.zones) >= maxTrackedZones {
+		for evict := range t.zones {
+			delete(t.zones, evict)
+			break
+		}
+	}
+
+	outcome := 0.0
+	if failed {
+		outcome = 1
+	}
+	if st.samples == 0 {
+		st.failureRate = outcome
+	} else {
+		st.failureRate += sampleWeight * (outcome - st.failureRate)
+	}
+	// Failures usually end in a timeout, only successful lookups say how fast recursion is
+	if !failed {
+		if st.latency == 0 {
+			st.latency = rtt
+		} else {
+			st.latency += time.Duration(sampleWeight * float64(rtt-st.latency))
+		}
+	}
+	st.samples++
+	st.updated = time.Now()
+	t.zones[zone] = st
+}
+
+// ObserveRecursion records how a recursive lookup for name went
+func ObserveRecursion(name string, rtt time.Duration, err error) {
+	stats.observe(statsZone(name), rtt, err != nil)
+}
+
+// statsZone groups names by their last two labels, so a.example.com and
+// b.example.com share the failure history of example.com
+func statsZone(name string) string {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	if len(labels) > 2 {
+		labels = labels[len(labels)-2:]
+	}
+	return dns.Fqdn(strings.Join(labels, "."))
+}
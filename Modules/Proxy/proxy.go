@@ -4,14 +4,21 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Access"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Forwarder"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
 )
 
 var (
 	dotTLSConfig *tls.Config
+	dotPool      *Forwarder.DoTPool
 	logProxy     *Logger.ModuleLogger
 )
 
@@ -44,66 +51,83 @@ func loadTLSConfig(certPath string) (*tls.Config, error) {
 	}, nil
 }
 
-// Initialize and start the proxy DNS server on port 53
-func InitProxy() error {
+// InitProxy prepares the optional forwarding mode, where plain DNS queries are relayed to
+// the DoT listener on dotAddr, trusting the certificate it serves
+func InitProxy(dotAddr, certPath string) error {
 	var err error
-	dotTLSConfig, err = loadTLSConfig("Modules/SSL/localhost.pem")
+	dotTLSConfig, err = loadTLSConfig(certPath)
 	if err != nil {
 		logProxy.Error("TLS config error: " + err.Error())
 		return err
 	}
 
-	dns.HandleFunc(".", ProxyToDoT)
-
-	go func() {
-		logProxy.Info("📡 Starting UDP proxy server on port 53")
-		udpServer := &dns.Server{Addr: ":53", Net: "udp"}
-		if err := udpServer.ListenAndServe(); err != nil {
-			logProxy.Error("Failed to start UDP proxy server: " + err.Error())
-		}
-	}()
+	conf := Loader.Current().Proxy.Pool
+	target := loopback(dotAddr)
+	dotPool = Forwarder.NewDoTPool(target, dotTLSConfig, Forwarder.PoolOptions{
+		Connections:  conf.Connections,
+		MaxInflight:  conf.MaxInflight,
+		IdleTimeout:  time.Duration(conf.IdleTimeout) * time.Second,
+		QueryTimeout: time.Duration(conf.QueryTimeout) * time.Second,
+		DialTimeout:  time.Duration(conf.QueryTimeout) * time.Second,
+	})
+
+	logProxy.Info("✅ DNS proxy will forward plain DNS queries to DoT on " + target)
+	return nil
+}
 
-	go func() {
-		logProxy.Info("📡 Starting TCP proxy server on port 53")
-		tcpServer := &dns.Server{Addr: ":53", Net: "tcp"}
-		if err := tcpServer.ListenAndServe(); err != nil {
-			logProxy.Error("Failed to start TCP proxy server: " + err.Error())
+// loopback turns a wildcard bind address into one the proxy can dial
+func loopback(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
 		}
-	}()
+	}
+	return net.JoinHostPort(host, port)
+}
 
-	logProxy.Info("✅ DNS proxy server is listening on port 53 and forwarding to DoT on port 853")
-	return nil
+// Handler relays queries from a plain DNS listener attached to view to the DoT server
+func Handler(view string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		proxyToDoT(view, w, r)
+	})
 }
 
 // Proxy DNS requests to the DoT server securely
-func ProxyToDoT(w dns.ResponseWriter, r *dns.Msg) {
-	domain := r.Question[0].Name
-	logProxy.Info(fmt.Sprintf("🔍 Received DNS query for: %s", domain))
-
-	conn, err := tls.Dial("tcp", "127.0.0.1:853", dotTLSConfig)
-	if err != nil {
-		logProxy.Error("❌ Failed to connect to DoT server: " + err.Error())
-		dns.HandleFailed(w, r)
+func proxyToDoT(view string, w dns.ResponseWriter, r *dns.Msg) {
+	if reason := Access.Check(Access.FromWriter(w).InView(view)); reason != "" {
+		if err := w.WriteMsg(Pipeline.Refused(r, reason)); err != nil {
+			logProxy.Warn("⚠️ Failed to send response back to client: " + err.Error())
+		}
 		return
 	}
-	defer conn.Close()
-
-	client := &dns.Conn{Conn: conn}
 
-	if err := client.WriteMsg(r); err != nil {
-		logProxy.Error("❌ Failed to send DNS query to DoT server: " + err.Error())
-		dns.HandleFailed(w, r)
+	if len(r.Question) == 0 {
+		logProxy.Warn("📛 Invalid DNS query format: no question in query")
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeFormatError)
+		if err := w.WriteMsg(m); err != nil {
+			logProxy.Warn("⚠️ Failed to send response back to client: " + err.Error())
+		}
 		return
 	}
 
-	resp, err := client.ReadMsg()
+	domain := r.Question[0].Name
+	logProxy.Info(fmt.Sprintf("🔍 Received DNS query for: %s", domain))
+
+	resp, err := dotPool.Exchange(r)
 	if err != nil {
-		logProxy.Error("❌ Failed to read DNS response from DoT server: " + err.Error())
+		logProxy.Error("❌ Failed to forward DNS query to DoT server: " + err.Error())
 		dns.HandleFailed(w, r)
 		return
 	}
 
 	logProxy.Info(fmt.Sprintf("✅ Successfully forwarded query for %s to DoT server", domain))
+	Pipeline.Truncate(w, r, resp)
 
 	if err := w.WriteMsg(resp); err != nil {
 		logProxy.Warn("⚠️ Failed to send response back to client: " + err.Error())
@@ -0,0 +1,271 @@
+package ProxyProtocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Systemd"
+)
+
+// PROXY protocol v2 signature, v1 headers start with "PROXY " instead
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	maxV1Header = 107
+
+	commandLocal = 0x0
+	commandProxy = 0x1
+
+	familyInet  = 0x1
+	familyInet6 = 0x2
+)
+
+var (
+	proxyLogger *Logger.ModuleLogger
+
+	headers = Metrics.NewCounterVec("hopzero_proxy_protocol_headers_total", "PROXY protocol headers received from trusted load balancers, by result", "result")
+)
+
+func init() {
+	var err error
+	proxyLogger, err = Logger.GetLogger("ProxyProtocol")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for ProxyProtocol module:", err)
+	}
+}
+
+// Listen opens a TCP listener (or takes the one systemd passed) that reads PROXY headers
+// from the trusted CIDRs in the config
+func Listen(addr string) (net.Listener, error) {
+	l, err := Systemd.ListenTCP(addr)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(l), nil
+}
+
+// Wrap makes Accept return connections whose RemoteAddr is the client conveyed in the PROXY
+// header. Trusted peers must send one; everyone else is served as is and never parsed.
+// Without trusted CIDRs the listener is returned unchanged.
+func Wrap(l net.Listener) net.Listener {
+	conf := Loader.Current().ProxyProtocol
+	if len(conf.TrustedCIDRs) == 0 {
+		return l
+	}
+
+	pl := &listener{
+		Listener: l,
+		timeout:  time.Duration(conf.HeaderTimeout) * time.Second,
+		ready:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	for _, cidr := range conf.TrustedCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			pl.trusted = append(pl.trusted, network)
+		}
+	}
+	go pl.acceptLoop()
+	return pl
+}
+
+type listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+
+	ready     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// acceptLoop reads headers off the accept path, so a slow load balancer connection cannot stall the others
+func (l *listener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if !l.isTrusted(c.RemoteAddr()) {
+			l.deliver(c)
+			continue
+		}
+		go func() {
+			conn, err := l.readHeader(c)
+			if err != nil {
+				headers.With("rejected").Inc()
+				proxyLogger.Warn(fmt.Sprintf("🚫 Dropped connection from %s: %v", c.RemoteAddr(), err))
+				c.Close()
+				return
+			}
+			l.deliver(conn)
+		}()
+	}
+}
+
+func (l *listener) deliver(c net.Conn) {
+	select {
+	case l.ready <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ready:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *listener) isTrusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.trusted {
+		if network.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// conn replays whatever followed the header and reports the conveyed client address
+type conn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *conn) Read(b []byte) (int, error) { return c.r.Read(b) }
+func (c *conn) RemoteAddr() net.Addr       { return c.remote }
+
+func (l *listener) readHeader(c net.Conn) (net.Conn, error) {
+	if err := c.SetReadDeadline(time.Now().Add(l.timeout)); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(c)
+	start, err := r.Peek(len(signatureV2))
+	if err != nil {
+		return nil, fmt.Errorf("no PROXY header: %w", err)
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.Equal(start, signatureV2):
+		remote, err = readV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		remote, err = readV1(r)
+	default:
+		err = errors.New("no PROXY header from a trusted source")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	// LOCAL and UNKNOWN headers are the balancer's own health checks
+	if remote == nil {
+		headers.With("local").Inc()
+		remote = c.RemoteAddr()
+	}
+	return &conn{Conn: c, r: r, remote: remote}, nil
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1Header {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header too long or not terminated")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("malformed PROXY v1 address in %q", line)
+	}
+	headers.With("v1").Inc()
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses the binary header; TLVs after the addresses are skipped
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY v2 version %d", fixed[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	command, family := fixed[12]&0x0f, fixed[13]>>4
+	switch {
+	case command == commandLocal:
+		return nil, nil
+	case command != commandProxy:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", command)
+	case family == familyInet && len(body) >= 12:
+		headers.With("v2").Inc()
+		return &net.TCPAddr{IP: net.IP(append([]byte{}, body[:4]...)), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case family == familyInet6 && len(body) >= 36:
+		headers.With("v2").Inc()
+		return &net.TCPAddr{IP: net.IP(append([]byte{}, body[:16]...)), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		// AF_UNSPEC and unix sockets carry no usable client address
+		return nil, nil
+	}
+}
@@ -2,46 +2,396 @@ package Redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
 	"github.com/redis/go-redis/v9"
 )
 
 var (
-	RedisClient *redis.Client
+	RedisClient redis.UniversalClient
 	Ctx         = context.Background()
 	redisLogger *Logger.ModuleLogger
+
+	// ErrUnavailable is returned while the circuit breaker bypasses Redis
+	ErrUnavailable = errors.New("redis unavailable")
+
+	callTimeout      = 2 * time.Second
+	failureThreshold = int32(5)
+	probeInterval    = 10 * time.Second
+
+	consecutiveFailures atomic.Int32
+	breakerOpen         atomic.Bool
+
+	// closed stops a running recovery probe once Close has been called
+	closed    = make(chan struct{})
+	closeOnce sync.Once
+
+	redisUp       = Metrics.NewGauge("hopzero_redis_up", "Whether Redis is reachable and used as cache (1) or bypassed (0)")
+	redisErrors   = Metrics.NewCounter("hopzero_redis_errors_total", "Redis calls that failed or timed out")
+	redisBypassed = Metrics.NewCounter("hopzero_redis_bypassed_total", "Redis calls skipped because the circuit breaker is open")
+	breakerTrips  = Metrics.NewCounter("hopzero_redis_breaker_trips_total", "Times the Redis circuit breaker opened")
 )
 
 // InitRedis initializes Redis client and sets up logger
 func InitRedis() {
-	conf := Loader.AppConfig.Redis
+	conf := Loader.Current().Redis
 
 	var err error
 	redisLogger, err = Logger.GetLogger("Redis_Logs.log")
 	if err != nil {
 		fmt.Printf("[Redis][ERROR] Logger init failed: %v\n", err)
-		return
 	}
 
-	LogInfo(fmt.Sprintf("Connecting to Redis server at %s...", conf.Addr))
+	if conf.ConnectionPool.Timeout > 0 {
+		callTimeout = time.Duration(conf.ConnectionPool.Timeout) * time.Second
+	}
+	if conf.CircuitBreaker.FailureThreshold > 0 {
+		failureThreshold = int32(conf.CircuitBreaker.FailureThreshold)
+	}
+	if conf.CircuitBreaker.ProbeInterval > 0 {
+		probeInterval = time.Duration(conf.CircuitBreaker.ProbeInterval) * time.Second
+	}
 
-	RedisClient = redis.NewClient(&redis.Options{
-		Addr:         conf.Addr,
-		Password:     conf.Password,
-		DB:           conf.DB,
-		PoolSize:     conf.ConnectionPool.MaxConnections,
-		MinIdleConns: conf.ConnectionPool.MaxConnections,
-	})
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		LogError(fmt.Sprintf("Redis TLS configuration error: %v", err))
+		return
+	}
+
+	RedisClient = newClient(tlsConfig)
+	LogInfo(fmt.Sprintf("Connecting to Redis (%s mode) at %s...", mode(), target()))
 
 	// Test connection
-	_, err = RedisClient.Ping(Ctx).Result()
+	ctx, cancel := callContext()
+	defer cancel()
+	_, err = RedisClient.Ping(ctx).Result()
 	if err != nil {
-		LogError(fmt.Sprintf("Failed to connect to Redis server at %s - %v", conf.Addr, err))
+		LogError(fmt.Sprintf("Failed to connect to Redis at %s - %v", target(), err))
+		tripBreaker()
 	} else {
-		LogInfo(fmt.Sprintf("Successfully connected to Redis server at %s", conf.Addr))
+		LogInfo(fmt.Sprintf("Successfully connected to Redis at %s", target()))
+		redisUp.Set(1)
+	}
+}
+
+// newClient builds a standalone, Sentinel failover or Cluster client from the redis config
+func newClient(tlsConfig *tls.Config) redis.UniversalClient {
+	conf := Loader.Current().Redis
+	pool := conf.ConnectionPool.MaxConnections
+
+	switch mode() {
+	case "sentinel":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       conf.MasterName,
+			SentinelAddrs:    conf.Addrs,
+			SentinelUsername: conf.SentinelUsername,
+			SentinelPassword: conf.SentinelPassword,
+			Username:         conf.Username,
+			Password:         conf.Password,
+			DB:               conf.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         pool,
+			MinIdleConns:     pool,
+			DialTimeout:      callTimeout,
+			ReadTimeout:      callTimeout,
+			WriteTimeout:     callTimeout,
+			PoolTimeout:      callTimeout,
+		})
+	case "cluster":
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        conf.Addrs,
+			Username:     conf.Username,
+			Password:     conf.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     pool,
+			MinIdleConns: pool,
+			DialTimeout:  callTimeout,
+			ReadTimeout:  callTimeout,
+			WriteTimeout: callTimeout,
+			PoolTimeout:  callTimeout,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         conf.Addr,
+			Username:     conf.Username,
+			Password:     conf.Password,
+			DB:           conf.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     pool,
+			MinIdleConns: pool,
+			DialTimeout:  callTimeout,
+			ReadTimeout:  callTimeout,
+			WriteTimeout: callTimeout,
+			PoolTimeout:  callTimeout,
+		})
+	}
+}
+
+// loadTLSConfig returns nil when TLS is disabled, otherwise a client config with the custom CA and certificate
+func loadTLSConfig() (*tls.Config, error) {
+	conf := Loader.Current().Redis.TLS
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: conf.ServerName,
+	}
+
+	if conf.CAFile != "" {
+		caCert, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", conf.CAFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if conf.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func mode() string {
+	if m := Loader.Current().Redis.Mode; m != "" {
+		return m
+	}
+	return "standalone"
+}
+
+func target() string {
+	conf := Loader.Current().Redis
+	if mode() == "standalone" {
+		return conf.Addr
+	}
+	return strings.Join(conf.Addrs, ",")
+}
+
+// Close releases the client's connections on shutdown, cache calls are bypassed afterwards
+func Close() error {
+	if RedisClient == nil {
+		return nil
+	}
+	breakerOpen.Store(true)
+	closeOnce.Do(func() { close(closed) })
+	redisUp.Set(0)
+	return RedisClient.Close()
+}
+
+// Available reports whether cache calls will be sent to Redis
+func Available() bool {
+	return RedisClient != nil && !breakerOpen.Load()
+}
+
+// Get fetches a value; redis.Nil means a miss, ErrUnavailable means Redis was bypassed
+func Get(key string) ([]byte, error) {
+	if !Available() {
+		redisBypassed.Inc()
+		return nil, ErrUnavailable
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+
+	val, err := RedisClient.Get(ctx, key).Bytes()
+	record(err)
+	return val, err
+}
+
+// Set stores a value with the given expiry
+func Set(key string, value []byte, ttl time.Duration) error {
+	if !Available() {
+		redisBypassed.Inc()
+		return ErrUnavailable
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+
+	err := RedisClient.Set(ctx, key, value, ttl).Err()
+	record(err)
+	return err
+}
+
+// Del removes the given keys
+func Del(keys ...string) error {
+	if !Available() {
+		redisBypassed.Inc()
+		return ErrUnavailable
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+
+	err := RedisClient.Del(ctx, keys...).Err()
+	record(err)
+	return err
+}
+
+// DeleteMatching unlinks every key matching a glob pattern, on all masters in cluster mode
+func DeleteMatching(pattern string) (int64, error) {
+	var deleted int64
+	err := scan(pattern, func(ctx context.Context, node redis.Cmdable, keys []string) error {
+		pipe := node.Pipeline()
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		deleted += int64(len(keys))
+		return nil
+	})
+	return deleted, err
+}
+
+// ScanKeys hands every key matching a glob pattern to fn in batches
+func ScanKeys(pattern string, fn func(keys []string) error) error {
+	return scan(pattern, func(_ context.Context, _ redis.Cmdable, keys []string) error {
+		return fn(keys)
+	})
+}
+
+// scan walks the keyspace with SCAN on one node, or on every master in cluster mode
+func scan(pattern string, fn func(ctx context.Context, node redis.Cmdable, keys []string) error) error {
+	if !Available() {
+		redisBypassed.Inc()
+		return ErrUnavailable
+	}
+
+	var err error
+	if cluster, ok := RedisClient.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		err = cluster.ForEachMaster(Ctx, func(_ context.Context, node *redis.Client) error {
+			return scanNode(node, pattern, func(ctx context.Context, node redis.Cmdable, keys []string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return fn(ctx, node, keys)
+			})
+		})
+	} else {
+		err = scanNode(RedisClient, pattern, fn)
+	}
+	record(err)
+	return err
+}
+
+// scanNode runs one SCAN cursor to completion, each batch bounded by the call timeout
+func scanNode(node redis.Cmdable, pattern string, fn func(ctx context.Context, node redis.Cmdable, keys []string) error) error {
+	var cursor uint64
+	for {
+		ctx, cancel := callContext()
+		keys, next, err := node.Scan(ctx, cursor, pattern, 500).Result()
+		if err == nil && len(keys) > 0 {
+			err = fn(ctx, node, keys)
+		}
+		cancel()
+
+		if err != nil {
+			return err
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Publish sends a message to all subscribers of a channel
+func Publish(channel string, payload []byte) error {
+	if !Available() {
+		redisBypassed.Inc()
+		return ErrUnavailable
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+
+	err := RedisClient.Publish(ctx, channel, payload).Err()
+	record(err)
+	return err
+}
+
+// Subscribe opens a subscription that reconnects on its own, or returns nil without a client
+func Subscribe(channel string) *redis.PubSub {
+	if RedisClient == nil {
+		return nil
+	}
+	return RedisClient.Subscribe(Ctx, channel)
+}
+
+// callContext bounds a single Redis round trip by connection_pool.timeout
+func callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(Ctx, callTimeout)
+}
+
+// record feeds the outcome of a Redis call into the circuit breaker
+func record(err error) {
+	if err == nil || errors.Is(err, redis.Nil) {
+		consecutiveFailures.Store(0)
+		return
+	}
+
+	redisErrors.Inc()
+	LogWarn(fmt.Sprintf("Redis call failed: %v", err))
+	if consecutiveFailures.Add(1) >= failureThreshold {
+		tripBreaker()
+	}
+}
+
+// tripBreaker opens the circuit and starts probing Redis until it answers again
+func tripBreaker() {
+	if !breakerOpen.CompareAndSwap(false, true) {
+		return
+	}
+	redisUp.Set(0)
+	breakerTrips.Inc()
+	LogError(fmt.Sprintf("Circuit breaker open: bypassing Redis cache, probing every %s", probeInterval))
+
+	go probe()
+}
+
+func probe() {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := callContext()
+		err := RedisClient.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			LogWarn(fmt.Sprintf("Redis recovery probe failed: %v", err))
+			continue
+		}
+
+		consecutiveFailures.Store(0)
+		breakerOpen.Store(false)
+		redisUp.Set(1)
+		LogInfo("Circuit breaker closed: Redis is reachable again")
+		return
 	}
 }
 
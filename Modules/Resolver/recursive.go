@@ -2,7 +2,7 @@ package Resolver
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -12,9 +12,9 @@ import (
 	"log"
 
 	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Cache"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/DNSSEC"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
-	"github.com/official-biswadeb941/HopZero-DNS/Modules/Redis"
 )
 
 type RootServer struct {
@@ -29,6 +29,9 @@ var (
 	resolverLogger  *Logger.ModuleLogger
 )
 
+// ErrClassNotImplemented is returned for queries outside class IN, the only class recursion follows
+var ErrClassNotImplemented = errors.New("only class IN is resolved")
+
 func init() {
 	var err error
 	resolverLogger, err = Logger.GetLogger("Resolver_Logs.log")
@@ -38,14 +41,18 @@ func init() {
 		os.Exit(1)
 	}
 	resolverLogger.Info("Logger initialized successfully")
+}
 
+// InitRootTrustAnchor loads the root DNSKEY from Confs/root.key, called once at startup
+func InitRootTrustAnchor() error {
 	key, err := loadRootTrustAnchor()
 	if err != nil {
 		resolverLogger.Error(fmt.Sprintf("Failed to load root trust anchor: %v", err))
-		os.Exit(1)
+		return err
 	}
 	resolverLogger.Info("Root trust anchor loaded successfully")
 	RootTrustAnchor = key
+	return nil
 }
 
 func loadRootServers() ([]RootServer, error) {
@@ -115,79 +122,127 @@ func loadRootTrustAnchor() (*dns.DNSKEY, error) {
 	return nil, fmt.Errorf("DNSKEY not found in root.key")
 }
 
+// RecursiveResolve resolves an IN query without the DO and CD bits set
 func RecursiveResolve(domain string, qtype uint16) ([]dns.RR, error) {
-	rootServers, err := loadRootServers()
-	if err != nil {
-		return nil, err
+	return Resolve(dns.Question{Name: dns.Fqdn(domain), Qtype: qtype, Qclass: dns.ClassINET}, false, false)
+}
+
+// Resolve answers a question from the cache or by iterating from the root servers
+func Resolve(q dns.Question, do, cd bool) ([]dns.RR, error) {
+	answers, _, err := resolve(q, do, cd)
+	return answers, err
+}
+
+func resolve(q dns.Question, do, cd bool) ([]dns.RR, bool, error) {
+	domain := q.Name
+	if q.Qclass != dns.ClassINET {
+		return nil, false, fmt.Errorf("%w, got %s for %s", ErrClassNotImplemented, dns.ClassToString[q.Qclass], domain)
+	}
+	if entry, ok := Cache.LookupRR(q, do, cd); ok {
+		resolverLogger.Info(fmt.Sprintf("Cache hit for domain: %s", domain))
+		return entry.Records(time.Now()), entry.Secure, nil
 	}
 
-	cacheKey := fmt.Sprintf("%s_%d", domain, qtype)
-	if cached, err := Redis.RedisClient.Get(Redis.Ctx, cacheKey).Result(); err == nil {
-		var answerStrs []string
-		if err := json.Unmarshal([]byte(cached), &answerStrs); err == nil {
-			var answers []dns.RR
-			for _, s := range answerStrs {
-				if rr, err := dns.NewRR(s); err == nil {
-					answers = append(answers, rr)
-				}
-			}
-			resolverLogger.Info(fmt.Sprintf("Cache hit for domain: %s", domain))
-			return answers, nil
+	zone := MatchZone(domain)
+	if zone != nil && zone.Type == ZoneForward {
+		return resolveForward(zone, q, do, cd)
+	}
+
+	// Start at the root servers, or at a stub zone's authoritative servers
+	var servers []string
+	if zone != nil {
+		resolverLogger.Info(fmt.Sprintf("Routing %s to stub zone %s", domain, zone.Name))
+		servers = zone.Servers
+	} else {
+		rootServers, err := loadRootServers()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, server := range rootServers {
+			servers = append(servers, net.JoinHostPort(server.Address, fmt.Sprintf("%d", server.Port)))
 		}
 	}
+	validate := zone == nil || !zone.Insecure
 
 	client := new(dns.Client)
 	client.Net = "udp"
 	client.Timeout = 5 * time.Second
 
 	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), qtype)
+	msg.SetQuestion(dns.Fqdn(domain), q.Qtype)
 
-	for _, server := range rootServers {
-		resolverLogger.Info(fmt.Sprintf("Querying root server: %s", server.Address))
-		resp, _, err := client.Exchange(msg, net.JoinHostPort(server.Address, fmt.Sprintf("%d", server.Port)))
+	for _, server := range servers {
+		resolverLogger.Info(fmt.Sprintf("Querying server: %s", server))
+		resp, _, err := client.Exchange(msg, server)
 		if err != nil {
-			resolverLogger.Warn(fmt.Sprintf("Query failed for %s: %v", server.Address, err))
+			resolverLogger.Warn(fmt.Sprintf("Query failed for %s: %v", server, err))
 			continue
 		}
-		answers, err := followChain(client, resp, qtype)
+		answers, secure, err := followChain(client, resp, q.Qtype, false, validate)
 		if err == nil && len(answers) > 0 {
-			var answerStrs []string
-			for _, rr := range answers {
-				answerStrs = append(answerStrs, rr.String())
-			}
-			if b, err := json.Marshal(answerStrs); err == nil {
-				ttl := time.Duration(answers[0].Header().Ttl) * time.Second
-				Redis.RedisClient.Set(Redis.Ctx, cacheKey, b, ttl)
+			if err := Cache.StoreRR(q, do, cd, Cache.NewEntry(answers, dns.RcodeSuccess, secure)); err != nil {
+				resolverLogger.Warn(fmt.Sprintf("Skipping cache store for %s: %v", domain, err))
 			}
 			resolverLogger.Info(fmt.Sprintf("Successfully resolved domain: %s", domain))
-			return answers, nil
+			return answers, secure, nil
 		}
 	}
 
 	resolverLogger.Error(fmt.Sprintf("Failed to resolve domain: %s", domain))
-	return nil, fmt.Errorf("failed to resolve domain: %s", domain)
+	return nil, false, fmt.Errorf("failed to resolve domain: %s", domain)
 }
 
-func followChain(client *dns.Client, msg *dns.Msg, qtype uint16) ([]dns.RR, error) {
+// resolveForward sends a recursive query to a forward zone's upstreams
+func resolveForward(zone *Zone, q dns.Question, do, cd bool) ([]dns.RR, bool, error) {
+	resolverLogger.Info(fmt.Sprintf("Forwarding %s to zone %s", q.Name, zone.Name))
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(q.Name), q.Qtype)
+	query.CheckingDisabled = cd
+	if do {
+		query.SetEdns0(1232, true)
+	}
+
+	resp, err := zone.Group.Exchange(query)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return nil, false, fmt.Errorf("zone %s answered %s with %d records", zone.Name, dns.RcodeToString[resp.Rcode], len(resp.Answer))
+	}
+
+	secure := resp.AuthenticatedData && !zone.Insecure
+	if err := Cache.StoreRR(q, do, cd, Cache.NewEntry(resp.Answer, dns.RcodeSuccess, secure)); err != nil {
+		resolverLogger.Warn(fmt.Sprintf("Skipping cache store for %s: %v", q.Name, err))
+	}
+	return resp.Answer, secure, nil
+}
+
+// followChain walks referrals until an answer is found; secure reports whether
+// the answering response passed enforced DNSSEC validation, validate is false for insecure zones
+func followChain(client *dns.Client, msg *dns.Msg, qtype uint16, secure, validate bool) ([]dns.RR, bool, error) {
 	if len(msg.Answer) > 0 {
 		var answers []dns.RR
 		for _, ans := range msg.Answer {
 			answers = append(answers, ans)
 			if cname, ok := ans.(*dns.CNAME); ok {
 				resolverLogger.Info(fmt.Sprintf("Following CNAME to: %s", cname.Target))
-				cnameAnswers, err := RecursiveResolve(cname.Target, qtype)
+				cnameAnswers, cnameSecure, err := resolve(dns.Question{Name: cname.Target, Qtype: qtype, Qclass: dns.ClassINET}, false, false)
 				if err == nil {
 					answers = append(answers, cnameAnswers...)
+					secure = secure && cnameSecure
 				}
 			}
 		}
-		return answers, nil
+		return answers, secure, nil
 	}
 
 	for _, rr := range msg.Ns {
 		if ns, ok := rr.(*dns.NS); ok {
-			nsIP := resolveNSIP(ns.Ns)
+			nsIP := glueIP(msg, ns.Ns)
+			if nsIP == "" {
+				nsIP = resolveNSIP(ns.Ns)
+			}
 			if nsIP == "" {
 				resolverLogger.Warn(fmt.Sprintf("Failed to resolve IP for NS: %s", ns.Ns))
 				continue
@@ -202,15 +257,35 @@ func followChain(client *dns.Client, msg *dns.Msg, qtype uint16) ([]dns.RR, erro
 				resolverLogger.Warn(fmt.Sprintf("Failed to query NS: %v", err))
 				continue
 			}
+			if !validate {
+				return followChain(client, resp, qtype, false, false)
+			}
 			if !DNSSEC.Validate(resp) {
 				resolverLogger.Error("DNSSEC validation failed: signature verification failed")
 				continue
 			}
 			resolverLogger.Info(fmt.Sprintf("DNSSEC validated for: %s", msg.Question[0].Name))
-			return followChain(client, resp, qtype)
+			return followChain(client, resp, qtype, DNSSEC.DNSSECEnforced, true)
+		}
+	}
+	return nil, false, fmt.Errorf("could not follow DNS chain")
+}
+
+// glueIP returns the address of a name server from the referral's additional section,
+// internal delegations are often only reachable this way
+func glueIP(msg *dns.Msg, ns string) string {
+	for _, rr := range msg.Extra {
+		if !strings.EqualFold(rr.Header().Name, ns) {
+			continue
+		}
+		switch glue := rr.(type) {
+		case *dns.A:
+			return glue.A.String()
+		case *dns.AAAA:
+			return glue.AAAA.String()
 		}
 	}
-	return nil, fmt.Errorf("could not follow DNS chain")
+	return ""
 }
 
 func resolveNSIP(ns string) string {
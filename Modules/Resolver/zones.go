@@ -0,0 +1,77 @@
+package Resolver
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Forwarder"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+)
+
+// Zone routing types
+const (
+	ZoneForward = "forward" // recursive queries go to the zone's forwarder group
+	ZoneStub    = "stub"    // iteration starts at the zone's authoritative servers
+)
+
+// Zone is one entry of the routing table, matched by domain suffix
+type Zone struct {
+	Name     string
+	Type     string
+	Insecure bool // skip DNSSEC validation and never report answers as secure
+	Group    *Forwarder.Group
+	Servers  []string
+}
+
+// zones is keyed by lowercase FQDN; InitZones swaps in a new table, a table is never modified
+var zones atomic.Pointer[map[string]*Zone]
+
+// InitZones builds the routing table from the zones section of the config
+func InitZones() error {
+	configured := Loader.Current().Zones
+	table := make(map[string]*Zone, len(configured))
+	for _, conf := range configured {
+		zone := &Zone{
+			Name:     strings.ToLower(dns.Fqdn(conf.Name)),
+			Type:     conf.Type,
+			Insecure: conf.DNSSEC == "insecure",
+			Servers:  conf.Servers,
+		}
+		if zone.Type == ZoneForward {
+			group, err := Forwarder.NewGroup(zone.Name, conf.Forwarder)
+			if err != nil {
+				return fmt.Errorf("zone %s: %w", zone.Name, err)
+			}
+			zone.Group = group
+		}
+		table[zone.Name] = zone
+		resolverLogger.Info(fmt.Sprintf("Routing %s as a %s zone", zone.Name, zone.Type))
+	}
+
+	// A reload builds a new table, the forward groups of the old one are retired
+	if old := zones.Swap(&table); old != nil {
+		for _, zone := range *old {
+			if zone.Group != nil {
+				zone.Group.Retire()
+			}
+		}
+	}
+	return nil
+}
+
+// MatchZone returns the zone with the longest suffix matching name, or nil for the root
+func MatchZone(name string) *Zone {
+	table := zones.Load()
+	if table == nil || len(*table) == 0 {
+		return nil
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if zone, ok := (*table)[name[off:]]; ok {
+			return zone
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package Sandbox
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	readRights = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	writeRights = readRights | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REFER
+
+	// Rights that mean something on a file, a rule on a file with any other right is rejected
+	fileRights = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// handledRights lists what each Landlock ABI version can restrict, starting at version 1.
+// Rights a kernel does not know cannot be denied by it, so they are left out of the ruleset.
+var handledRights = []uint64{
+	1: unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1, // EXECUTE through MAKE_SYM
+	2: unix.LANDLOCK_ACCESS_FS_REFER,
+	3: unix.LANDLOCK_ACCESS_FS_TRUNCATE,
+	5: unix.LANDLOCK_ACCESS_FS_IOCTL_DEV,
+}
+
+// restrictFilesystem limits every thread to reading below readOnly and writing below
+// readWrite. Paths are resolved against the working directory; missing ones are skipped.
+func restrictFilesystem(readOnly, readWrite []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("not supported by this kernel: %w", errno)
+	}
+	var handled uint64
+	for version := 1; version < len(handledRights) && version <= int(abi); version++ {
+		handled |= handledRights[version]
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	for _, path := range readOnly {
+		if err := allowPath(ruleset, path, readRights&handled); err != nil {
+			return err
+		}
+	}
+	for _, path := range readWrite {
+		if err := allowPath(ruleset, path, writeRights&handled); err != nil {
+			return err
+		}
+	}
+
+	// An unprivileged process may only sandbox itself once it cannot gain privileges
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+		return err
+	}
+	if err := allThreads(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); err != nil {
+		return fmt.Errorf("restricting threads: %w", err)
+	}
+	sandboxLogger.Info(fmt.Sprintf("🧱 Landlock ABI v%d restricts files to %d read-only and %d read-write path(s)", abi, len(readOnly), len(readWrite)))
+	return nil
+}
+
+// allowPath adds a rule granting rights below path, only the file rights when it is a file
+func allowPath(ruleset int, path string, rights uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			sandboxLogger.Warn(fmt.Sprintf("⚠️ Landlock path %s does not exist, skipping it", path))
+			return nil
+		}
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		rights &= fileRights
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: rights, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("adding rule for %s: %w", path, errno)
+	}
+	return nil
+}
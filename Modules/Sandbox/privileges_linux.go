@@ -0,0 +1,112 @@
+package Sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// errCgo is returned when per-thread state has to change on every thread at once, which the
+// Go runtime can only do for binaries without cgo
+var errCgo = errors.New("needs a binary built with CGO_ENABLED=0, threads started by cgo cannot all be changed")
+
+// allThreads runs a syscall whose effect the kernel keeps per thread on every thread of the
+// process, so no goroutine is left running with the old state
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ENOTSUP:
+		return errCgo
+	}
+	return errno
+}
+
+// dropPrivileges switches from root to ids, keeping CAP_NET_BIND_SERVICE when asked. Without
+// a user, keepNetBind leaves the process as uid 0 with that one capability and an emptied
+// bounding set, so a later exploit can bind ports but not load modules or read every file.
+func dropPrivileges(ids *credentials, keepNetBind bool) error {
+	if euid := os.Geteuid(); euid != 0 {
+		if ids != nil {
+			sandboxLogger.Warn(fmt.Sprintf("⚠️ Already running as uid %d, not switching to %s", euid, ids.name))
+		}
+		return nil
+	}
+
+	if ids == nil {
+		if !keepNetBind {
+			sandboxLogger.Warn("⚠️ Running as root, set sandbox.user or sandbox.keep_net_bind_service to drop privileges")
+			return nil
+		}
+		if err := limitBoundingSet(unix.CAP_NET_BIND_SERVICE); err != nil {
+			return err
+		}
+		if err := setCapabilities(unix.CAP_NET_BIND_SERVICE); err != nil {
+			return err
+		}
+		sandboxLogger.Info("🔐 Kept only CAP_NET_BIND_SERVICE, still running as uid 0")
+		return nil
+	}
+
+	// Capabilities survive the uid change only with KEEPCAPS set on the thread making it
+	if keepNetBind {
+		if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0); err != nil {
+			return err
+		}
+	}
+	// The Go runtime applies these to every thread
+	if err := syscall.Setgroups([]int{ids.gid}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(ids.gid); err != nil {
+		return err
+	}
+	if err := syscall.Setuid(ids.uid); err != nil {
+		return err
+	}
+	if keepNetBind {
+		if err := setCapabilities(unix.CAP_NET_BIND_SERVICE); err != nil {
+			return err
+		}
+	}
+
+	kept := ""
+	if keepNetBind {
+		kept = ", keeping CAP_NET_BIND_SERVICE"
+	}
+	sandboxLogger.Info(fmt.Sprintf("👤 Dropped privileges to %s (uid %d, gid %d)%s", ids.name, ids.uid, ids.gid, kept))
+	return nil
+}
+
+// setCapabilities makes caps the only permitted and effective capabilities
+func setCapabilities(caps ...int) error {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	for _, c := range caps {
+		data[c/32].Permitted |= 1 << (c % 32)
+		data[c/32].Effective |= 1 << (c % 32)
+	}
+	return allThreads(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+}
+
+// limitBoundingSet drops every capability but keep from the bounding set, so none can be
+// regained by executing a file. The kernel reports EINVAL past its last capability.
+func limitBoundingSet(keep int) error {
+	for c := 0; ; c++ {
+		if c == keep {
+			continue
+		}
+		err := allThreads(unix.SYS_PRCTL, unix.PR_CAPBSET_DROP, uintptr(c), 0)
+		if errors.Is(err, syscall.EINVAL) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package Sandbox
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+)
+
+var sandboxLogger *Logger.ModuleLogger
+
+func init() {
+	var err error
+	sandboxLogger, err = Logger.GetLogger("Sandbox")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Sandbox module:", err)
+	}
+}
+
+// credentials is the user and group the process switches to
+type credentials struct {
+	name string
+	uid  int
+	gid  int
+}
+
+// Apply gives up what the server no longer needs once every listener is bound: root, the
+// filesystem outside the configured paths and the syscalls outside the allow-list. An error
+// means a configured step could not be applied, and the caller should not serve half-sandboxed.
+func Apply() error {
+	conf := Loader.Current().Sandbox
+
+	// Resolve names first, Landlock may hide /etc/passwd afterwards
+	var ids *credentials
+	if conf.User != "" {
+		var err error
+		if ids, err = lookup(conf.User, conf.Group); err != nil {
+			return err
+		}
+	}
+
+	if conf.Landlock.Enabled {
+		if err := restrictFilesystem(conf.Landlock.ReadOnly, conf.Landlock.ReadWrite); err != nil {
+			return fmt.Errorf("landlock: %w", err)
+		}
+	}
+	if err := dropPrivileges(ids, conf.KeepNetBindService); err != nil {
+		return fmt.Errorf("dropping privileges: %w", err)
+	}
+	// Last, the filter also blocks the setuid and prctl calls used above
+	if conf.Seccomp.Enabled {
+		if err := restrictSyscalls(conf.Seccomp.Mode == "log"); err != nil {
+			return fmt.Errorf("seccomp: %w", err)
+		}
+	}
+	return nil
+}
+
+// lookup resolves the user, and the group when given, otherwise the user's primary group
+func lookup(userName, groupName string) (*credentials, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	ids := &credentials{name: userName}
+	if ids.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("user %s has a non-numeric uid %q", userName, u.Uid)
+	}
+
+	gid := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		gid = g.Gid
+	}
+	if ids.gid, err = strconv.Atoi(gid); err != nil {
+		return nil, fmt.Errorf("group of %s has a non-numeric gid %q", userName, gid)
+	}
+	return ids, nil
+}
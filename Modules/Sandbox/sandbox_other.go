@@ -0,0 +1,28 @@
+//go:build !linux
+
+package Sandbox
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("only supported on Linux")
+
+func dropPrivileges(ids *credentials, keepNetBind bool) error {
+	if ids == nil && !keepNetBind {
+		if os.Geteuid() == 0 {
+			sandboxLogger.Warn("⚠️ Running as root, privileges are only dropped on Linux")
+		}
+		return nil
+	}
+	return errUnsupported
+}
+
+func restrictFilesystem(readOnly, readWrite []string) error {
+	return errUnsupported
+}
+
+func restrictSyscalls(logOnly bool) error {
+	return errUnsupported
+}
@@ -0,0 +1,92 @@
+package Sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into struct seccomp_data, the input of the filter. The low half of the first
+// argument comes first on the little-endian architectures with an allow-list.
+const (
+	seccompDataNr      = 0
+	seccompDataArch    = 4
+	seccompDataArg0Low = 16
+)
+
+// restrictSyscalls installs a filter on every thread that allows the syscalls in
+// allowedSyscalls, and clone for new threads only. Others fail with EPERM, or with logOnly
+// are allowed and logged by the kernel so a missing entry can be found before enforcing.
+func restrictSyscalls(logOnly bool) error {
+	if auditArch == 0 {
+		return fmt.Errorf("no syscall allow-list for %s", runtime.GOARCH)
+	}
+
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	if logOnly {
+		deny = unix.SECCOMP_RET_LOG
+	}
+	filter := buildFilter(auditArch, allowedSyscalls, deny)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// The thread installing the filter needs no_new_privs, TSYNC copies both to the others
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	r, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	if r != 0 {
+		return fmt.Errorf("thread %d could not be synchronized", r)
+	}
+
+	mode := "enforcing"
+	if logOnly {
+		mode = "logging"
+	}
+	sandboxLogger.Info(fmt.Sprintf("🧰 Seccomp filter %s, %d syscalls allowed and clone for threads only", mode, len(allowedSyscalls)))
+	return nil
+}
+
+// buildFilter returns a classic BPF program that kills the process for a foreign
+// architecture, returns allow for the listed syscall numbers and deny for the rest.
+// clone is allowed with CLONE_THREAD, which is how the Go runtime and libc start threads,
+// so no child process can be created. clone3 passes its flags in memory a filter cannot
+// read, it fails with ENOSYS and both fall back to clone.
+func buildFilter(arch uint32, allowed []uintptr, deny uint32) []unix.SockFilter {
+	load := func(offset uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+	}
+	ret := func(action uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: action}
+	}
+	// Falls through on a match, skips the next instruction otherwise
+	equal := func(value uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 1, K: value}
+	}
+
+	filter := []unix.SockFilter{
+		load(seccompDataArch),
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, Jf: 0, K: arch},
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		load(seccompDataNr),
+
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 4, K: unix.SYS_CLONE},
+		load(seccompDataArg0Low),
+		{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 0, Jf: 1, K: unix.CLONE_THREAD},
+		ret(unix.SECCOMP_RET_ALLOW),
+		ret(deny),
+
+		equal(unix.SYS_CLONE3),
+		ret(unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)),
+	}
+	for _, nr := range allowed {
+		filter = append(filter, equal(uint32(nr)), ret(unix.SECCOMP_RET_ALLOW))
+	}
+	return append(filter, ret(deny))
+}
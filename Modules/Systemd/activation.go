@@ -0,0 +1,239 @@
+package Systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"golang.org/x/sys/unix"
+)
+
+// First descriptor systemd passes, sockets follow in the order of the .socket unit
+const listenFDsStart = 3
+
+var (
+	systemdLogger *Logger.ModuleLogger
+
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   []*os.File // nil entries have been claimed by a listener
+)
+
+func init() {
+	var err error
+	systemdLogger, err = Logger.GetLogger("Systemd")
+	if err != nil {
+		fmt.Println("Fallback: failed to initialize logger for Systemd module:", err)
+	}
+}
+
+// inherit takes the sockets from LISTEN_FDS once. The variables are cleared so they do not
+// leak into child processes, as sd_listen_fds(1) does.
+func inherit() {
+	inheritOnce.Do(func() {
+		pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		if pid != os.Getpid() || count <= 0 {
+			return
+		}
+
+		for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+			unix.CloseOnExec(fd)
+			inherited = append(inherited, os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd)))
+		}
+		systemdLogger.Info(fmt.Sprintf("🧷 Inherited %d socket(s) from systemd", count))
+	})
+}
+
+// ListenTCP returns the stream socket systemd passed for addr, or binds a new one
+func ListenTCP(addr string) (net.Listener, error) {
+	if f := claim(unix.SOCK_STREAM, addr); f != nil {
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ListenUDP returns the datagram socket systemd passed for addr, or binds a new one
+func ListenUDP(addr string) (*net.UDPConn, error) {
+	if f := claim(unix.SOCK_DGRAM, addr); f != nil {
+		defer f.Close()
+		conn, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, err
+		}
+		udp, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			return nil, fmt.Errorf("socket passed for udp %s is not a UDP socket", addr)
+		}
+		return udp, nil
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", udpAddr)
+}
+
+// ListenUDPReusePort returns up to n datagram sockets on addr sharing the port through
+// SO_REUSEPORT, so the kernel spreads packets across them by client address. Sockets systemd
+// passed for addr come first and the rest are bound here. When the port cannot be shared any
+// further, for example because the systemd socket lacks ReusePort=yes, it settles for the
+// sockets it has.
+func ListenUDPReusePort(addr string, n int) ([]*net.UDPConn, error) {
+	if n <= 1 {
+		conn, err := ListenUDP(addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+
+	var conns []*net.UDPConn
+	closeAll := func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	for len(conns) < n {
+		f := claim(unix.SOCK_DGRAM, addr)
+		if f == nil {
+			break
+		}
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		udp, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			closeAll()
+			return nil, fmt.Errorf("socket passed for udp %s is not a UDP socket", addr)
+		}
+		conns = append(conns, udp)
+	}
+
+	lc := net.ListenConfig{Control: setReusePort}
+	for len(conns) < n {
+		// Siblings bind the port the first socket got, which matters for ":0"
+		bindAddr := addr
+		if len(conns) > 0 {
+			bindAddr = conns[0].LocalAddr().String()
+		}
+		conn, err := lc.ListenPacket(context.Background(), "udp", bindAddr)
+		if err != nil {
+			if len(conns) == 0 {
+				return nil, err
+			}
+			systemdLogger.Warn(fmt.Sprintf("⚠️ Cannot share udp %s with more SO_REUSEPORT sockets, serving it with %d: %v", addr, len(conns), err))
+			break
+		}
+		conns = append(conns, conn.(*net.UDPConn))
+	}
+	return conns, nil
+}
+
+func setReusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// CloseUnclaimed closes inherited sockets no listener asked for, they would otherwise
+// queue traffic nobody reads
+func CloseUnclaimed() {
+	inherit()
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+
+	for i, f := range inherited {
+		if f == nil {
+			continue
+		}
+		systemdLogger.Warn(fmt.Sprintf("⚠️ Socket %s from systemd matches no configured listener, closing it", describe(f)))
+		f.Close()
+		inherited[i] = nil
+	}
+}
+
+// claim hands out the inherited socket of the given type bound to addr, at most once
+func claim(sockType int, addr string) *os.File {
+	inherit()
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+
+	for i, f := range inherited {
+		if f == nil {
+			continue
+		}
+		fd := int(f.Fd())
+		if t, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE); err != nil || t != sockType {
+			continue
+		}
+		sa, err := unix.Getsockname(fd)
+		if err != nil || !boundTo(sa, addr) {
+			continue
+		}
+		inherited[i] = nil
+		systemdLogger.Info(fmt.Sprintf("🧷 Using the systemd socket for %s", addr))
+		return f
+	}
+	return nil
+}
+
+// boundTo compares a socket address with a listener addr. An empty host matches
+// only a wildcard socket, which is what systemd binds for ListenStream=53.
+func boundTo(sa unix.Sockaddr, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	var ip net.IP
+	var p int
+	switch a := sa.(type) {
+	case *unix.SockaddrInet4:
+		ip, p = net.IP(a.Addr[:]), a.Port
+	case *unix.SockaddrInet6:
+		ip, p = net.IP(a.Addr[:]), a.Port
+	default:
+		return false
+	}
+	if strconv.Itoa(p) != port {
+		return false
+	}
+	if host == "" {
+		return ip.IsUnspecified()
+	}
+	return ip.Equal(net.ParseIP(host))
+}
+
+func describe(f *os.File) string {
+	sa, err := unix.Getsockname(int(f.Fd()))
+	if err != nil {
+		return f.Name()
+	}
+	switch a := sa.(type) {
+	case *unix.SockaddrInet4:
+		return net.JoinHostPort(net.IP(a.Addr[:]).String(), strconv.Itoa(a.Port))
+	case *unix.SockaddrInet6:
+		return net.JoinHostPort(net.IP(a.Addr[:]).String(), strconv.Itoa(a.Port))
+	}
+	return f.Name()
+}
@@ -0,0 +1,101 @@
+package Systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Notify sends a state such as "READY=1" to NOTIFY_SOCKET. Without systemd it does nothing.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract namespace sockets are given with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Ready tells systemd the listeners are up, together with a status line
+func Ready(status string) {
+	send("READY=1\nSTATUS=" + status)
+}
+
+// Reloading marks the start of a SIGHUP reload, Ready ends it
+func Reloading() {
+	send(fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d\nSTATUS=Reloading configuration", monotonicUsec()))
+}
+
+// Stopping tells systemd the listeners are draining
+func Stopping(status string) {
+	send("STOPPING=1\nSTATUS=" + status)
+}
+
+// Status updates the line shown by systemctl status
+func Status(status string) {
+	send("STATUS=" + status)
+}
+
+func send(state string) {
+	if err := Notify(state); err != nil {
+		systemdLogger.Warn("⚠️ Failed to notify systemd: " + err.Error())
+	}
+}
+
+// WatchdogInterval returns how often to ping the watchdog, half the WatchdogSec of the unit,
+// or 0 when the watchdog is off or meant for another process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// Watchdog pings systemd every interval while healthy reports no error. A failing check
+// skips the ping, so systemd restarts a resolver that stopped answering instead of one that
+// is merely alive.
+func Watchdog(healthy func() error) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	systemdLogger.Info(fmt.Sprintf("🐕 Watchdog enabled, checking health every %s", interval))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := healthy(); err != nil {
+				systemdLogger.Warn("⚠️ Health check failed, withholding the watchdog ping: " + err.Error())
+				continue
+			}
+			send("WATCHDOG=1")
+		}
+	}()
+}
+
+func monotonicUsec() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return ts.Nano() / int64(time.Microsecond)
+}
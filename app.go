@@ -1,18 +1,49 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/CLI"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Cache"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Certs"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/DNSCrypt"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Do53"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/DoH"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/DoQ"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/DoT"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Forwarder"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Loader"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Logger"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Metrics"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Pipeline"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Proxy"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Redis"
 	"github.com/official-biswadeb941/HopZero-DNS/Modules/Resolver"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Sandbox"
+	"github.com/official-biswadeb941/HopZero-DNS/Modules/Systemd"
+)
+
+const (
+	configPath = "Modules/Config/Config.yaml"
+	// How long the watchdog health query may take
+	healthTimeout = 5 * time.Second
 )
 
 var logApp *Logger.ModuleLogger
 
+// server is a running listener that can drain its in-flight queries
+type server interface {
+	Stop(ctx context.Context) error
+}
+
 func main() {
 	var err error
 	logApp, err = Logger.GetLogger("App")
@@ -21,61 +52,281 @@ func main() {
 		return
 	}
 
-	// 🔀 Feature toggles
-	enableProxy := true // toggle DNS proxy on port 53
-	enableDoT := true   // toggle DNS-over-TLS on port 853
+	// Load configuration
+	if err := Loader.LoadConfig(configPath); err != nil {
+		logApp.Error(err.Error())
+		return
+	}
+
+	// Run a one-shot command such as "cache flush" instead of serving
+	if len(os.Args) > 1 {
+		Redis.InitRedis()
+		if err := CLI.Run(os.Args[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Startup reads one config throughout, sections a reload cannot change are taken from it
+	conf := Loader.Current()
+
+	// Expose metrics if enabled
+	if conf.Metrics.Enabled {
+		Metrics.Serve(conf.Metrics.Addr)
+	}
 
 	// Initialize Redis
 	Redis.InitRedis()
-	logApp.Info("🔌 Redis cache initialized")
+	if Redis.Available() {
+		logApp.Info("🔌 Redis cache initialized")
+	} else {
+		logApp.Warn("⚠️ Redis is unreachable, resolving without cache until it recovers")
+	}
+	Cache.InitCache()
+
+	// Warm the cache from the last snapshot, a new one is written on shutdown
+	snapshot := conf.Cache.Snapshot
+	if snapshot.LoadOnStartup {
+		if n, err := Cache.LoadSnapshot(snapshot.Path); err != nil {
+			logApp.Warn("⚠️ Cache snapshot not loaded: " + err.Error())
+		} else {
+			logApp.Info(fmt.Sprintf("♻️ Warm start with %d cached entries", n))
+		}
+	}
+
+	if err := Resolver.InitRootTrustAnchor(); err != nil {
+		logApp.Error("❌ Failed to load the root trust anchor: " + err.Error())
+		return
+	}
 
-	// Start DNS Proxy if enabled
-	if enableProxy {
-		if err := Proxy.InitProxy(); err != nil {
+	// Set up the forwarder upstreams used by resolver modes forward and auto
+	if err := Forwarder.InitForwarder(); err != nil {
+		logApp.Error("❌ Failed to initialize forwarder: " + err.Error())
+		return
+	}
+	if err := Resolver.InitZones(); err != nil {
+		logApp.Error("❌ Failed to build zone routing table: " + err.Error())
+		return
+	}
+	logApp.Info(fmt.Sprintf("🧭 Resolver running in %s mode with %d routed zone(s)", conf.Resolver.Mode, len(conf.Zones)))
+
+	// Relay plain DNS to the first DoT listener when do53.mode is forward-dot
+	if conf.Do53.Mode == "forward-dot" {
+		dot := firstListener(conf, "dot")
+		if dot == nil {
+			logApp.Error("❌ do53.mode forward-dot needs a dot listener")
+			return
+		}
+		if err := Proxy.InitProxy(dot.Addr, conf.TLS.Profiles[dot.TLS].CertFile); err != nil {
 			logApp.Error("❌ Failed to start DNS proxy server: " + err.Error())
 			return
 		}
-		logApp.Info("📡 DNS proxy is active on port 53 and forwarding to DoT")
 	}
 
-	// Start DoT Server if enabled
-	if enableDoT {
-		dotServer, err := DoT.NewDoTServer(":853", "Modules/SSL/localhost.pem", "Modules/SSL/localhost-key.pem")
+	// Take over the signals before listening so a SIGTERM during startup still drains
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Start every configured listener, plain DNS ones first in their own stop phase
+	var plain, encrypted []server
+	for _, l := range conf.Listeners {
+		srv, err := startListener(conf, l)
 		if err != nil {
-			logApp.Error("❌ Failed to initialize DoT server: " + err.Error())
+			logApp.Error(fmt.Sprintf("❌ Failed to start %s listener on %s: %v", l.Protocol, l.Addr, err))
+			shutdown(plain, encrypted)
 			return
 		}
-		logApp.Info("🚀 DNS-over-TLS server is running on port 853")
+		if l.Protocol == "udp" || l.Protocol == "tcp" {
+			plain = append(plain, srv)
+		} else {
+			encrypted = append(encrypted, srv)
+		}
+	}
+	Systemd.CloseUnclaimed()
 
-		if err := dotServer.Start(); err != nil {
-			logApp.Error("❌ Failed to start DoT server: " + err.Error())
+	// Every socket is bound, so root and the rest of the system are no longer needed
+	if err := Sandbox.Apply(); err != nil {
+		logApp.Error("❌ Failed to sandbox the server: " + err.Error())
+		shutdown(plain, encrypted)
+		return
+	}
+	Systemd.Ready(fmt.Sprintf("Serving on %d listener(s)", len(conf.Listeners)))
+	Systemd.Watchdog(healthy)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			reload()
+			continue
 		}
+		logApp.Info(fmt.Sprintf("🛑 Received %s, draining listeners", sig))
+		Systemd.Stopping("Draining listeners")
+		shutdown(plain, encrypted)
+		return
 	}
 }
 
-// DoT query handler
-func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
-	msg := new(dns.Msg)
-	msg.SetReply(r)
-	msg.Authoritative = true
-
-	if len(r.Question) == 0 {
-		msg.Rcode = dns.RcodeFormatError
-		_ = w.WriteMsg(msg)
-		logApp.Error("📛 Invalid DNS query format: no question in query")
+// reload re-reads Config.yaml and rebuilds what depends on it. An invalid file is rejected
+// as a whole and the running config stays in place.
+func reload() {
+	logApp.Info("🔔 SIGHUP received, reloading configuration")
+	Systemd.Reloading()
+	restart, err := Loader.ReloadConfig(configPath)
+	if err != nil {
+		logApp.Error("❌ Config reload rejected, keeping the running config: " + err.Error())
+		Systemd.Ready("Config reload rejected, see App.log")
 		return
 	}
+	if err := Forwarder.InitForwarder(); err != nil {
+		logApp.Error("❌ Failed to rebuild forwarder after reload: " + err.Error())
+	}
+	if err := Resolver.InitZones(); err != nil {
+		logApp.Error("❌ Failed to rebuild zone routing table after reload: " + err.Error())
+	}
+	Certs.ReloadAll()
+	if len(restart) > 0 {
+		logApp.Warn("⚠️ Changes to " + strings.Join(restart, ", ") + " take effect after a restart")
+	}
+	conf := Loader.Current()
+	logApp.Info(fmt.Sprintf("🧭 Reloaded: resolver in %s mode with %d routed zone(s)", conf.Resolver.Mode, len(conf.Zones)))
+	Systemd.Ready(fmt.Sprintf("Serving on %d listener(s), config reloaded", len(conf.Listeners)))
+}
 
-	question := r.Question[0]
-	logApp.Info(fmt.Sprintf("📨 Received query for %s (%s)", question.Name, dns.TypeToString[question.Qtype]))
+// healthy resolves the root NS set through the pipeline the way a client query goes, cache
+// and resolver or forwarder included, so a stuck resolver stops feeding the systemd watchdog.
+// The answer is cached for days after the first probe, so the check rarely leaves the host.
+func healthy() error {
+	query := new(dns.Msg)
+	query.SetQuestion(".", dns.TypeNS)
 
-	answers, err := Resolver.RecursiveResolve(question.Name, question.Qtype)
-	if err != nil {
-		logApp.Warn(fmt.Sprintf("❌ Failed to resolve %s: %s", question.Name, err.Error()))
-		msg.Rcode = dns.RcodeServerFailure
-	} else {
-		msg.Answer = answers
+	done := make(chan *dns.Msg, 1)
+	go func() { done <- Pipeline.Answer(query) }()
+	select {
+	case resp := <-done:
+		if resp.Rcode == dns.RcodeServerFailure {
+			return fmt.Errorf("pipeline answered SERVFAIL")
+		}
+		return nil
+	case <-time.After(healthTimeout):
+		return fmt.Errorf("pipeline did not answer within %s", healthTimeout)
 	}
+}
+
+// shutdown drains the listeners within shutdown.timeout, then saves the cache snapshot,
+// closes Redis and flushes the logs. Plain DNS listeners stop first because in forward-dot
+// mode their queries still need the DoT listener.
+func shutdown(phases ...[]server) {
+	conf := Loader.Current()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.Shutdown.Timeout)*time.Second)
+	defer cancel()
 
-	_ = w.WriteMsg(msg)
+	for _, servers := range phases {
+		var wg sync.WaitGroup
+		for _, srv := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := srv.Stop(ctx); err != nil {
+					logApp.Warn("⚠️ Listener did not drain cleanly: " + err.Error())
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	if snapshot := conf.Cache.Snapshot; snapshot.SaveOnShutdown {
+		if n, err := Cache.WriteSnapshot(snapshot.Path); err != nil {
+			logApp.Error("❌ Failed to write cache snapshot: " + err.Error())
+		} else {
+			logApp.Info(fmt.Sprintf("💾 Wrote %d cached entries to %s", n, snapshot.Path))
+		}
+	}
+	if err := Redis.Close(); err != nil {
+		logApp.Warn("⚠️ Failed to close Redis: " + err.Error())
+	}
+	logApp.Info("👋 Shutdown complete")
+	Logger.Sync()
+}
+
+// firstListener returns the first listener of protocol, or nil
+func firstListener(conf *Loader.Config, protocol string) *Loader.Listener {
+	for i, l := range conf.Listeners {
+		if l.Protocol == protocol {
+			return &conf.Listeners[i]
+		}
+	}
+	return nil
+}
+
+// startListener brings up one endpoint from the listeners section. It returns once the socket
+// is bound, serving continues in the background.
+func startListener(conf *Loader.Config, l Loader.Listener) (server, error) {
+	profile := conf.TLS.Profiles[l.TLS]
+	view := l.View
+	if view != "" {
+		view = " [" + view + "]"
+	}
+
+	switch l.Protocol {
+	case "udp", "tcp":
+		handler := Pipeline.Handler(l.View)
+		if conf.Do53.Mode == "forward-dot" {
+			handler = Proxy.Handler(l.View)
+		}
+		do53Server := Do53.NewDo53Server(l.Protocol, l.Addr, l.Sockets, l.Workers, handler)
+		if err := do53Server.Start(); err != nil {
+			return nil, err
+		}
+		logApp.Info(fmt.Sprintf("📡 DNS over %s is active on %s in %s mode%s", l.Protocol, l.Addr, conf.Do53.Mode, view))
+		return do53Server, nil
+
+	case "dot":
+		dotServer, err := DoT.NewDoTServer(l.Addr, profile.CertFile, profile.KeyFile, l.View)
+		if err != nil {
+			return nil, err
+		}
+		if err := dotServer.Start(); err != nil {
+			return nil, err
+		}
+		logApp.Info(fmt.Sprintf("🚀 DNS-over-TLS server is running on %s%s", l.Addr, view))
+		return dotServer, nil
+
+	case "doh":
+		path := l.Path
+		if path == "" {
+			path = DoH.DefaultPath
+		}
+		dohServer, err := DoH.NewDoHServer(l.Addr, path, profile.CertFile, profile.KeyFile, l.View)
+		if err != nil {
+			return nil, err
+		}
+		if err := dohServer.Start(); err != nil {
+			return nil, err
+		}
+		logApp.Info(fmt.Sprintf("🌐 DNS-over-HTTPS server is running on %s%s%s", l.Addr, path, view))
+		return dohServer, nil
+
+	case "doq":
+		doqServer, err := DoQ.NewDoQServer(l.Addr, profile.CertFile, profile.KeyFile, l.View)
+		if err != nil {
+			return nil, err
+		}
+		if err := doqServer.Start(); err != nil {
+			return nil, err
+		}
+		logApp.Info(fmt.Sprintf("⚡ DNS-over-QUIC server is running on UDP %s%s", l.Addr, view))
+		return doqServer, nil
+
+	case "dnscrypt":
+		dnscryptServer, err := DNSCrypt.NewDNSCryptServer(l.Addr, l.View, l.Workers)
+		if err != nil {
+			return nil, err
+		}
+		if err := dnscryptServer.Start(); err != nil {
+			return nil, err
+		}
+		logApp.Info(fmt.Sprintf("🧂 DNSCrypt server is running on %s%s", l.Addr, view))
+		return dnscryptServer, nil
+	}
+	return nil, fmt.Errorf("unknown protocol %q", l.Protocol)
 }